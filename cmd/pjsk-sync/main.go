@@ -2,35 +2,451 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
 
 	"pjsk-sync/internal/config"
 	"pjsk-sync/internal/db"
 	"pjsk-sync/internal/sync"
 )
 
+const usage = `usage: pjsk-sync <command> [flags]
+
+commands:
+  sync [db|assets]              sync master data and assets (default: both)
+  migrate [up|down [N]|status]  manage the database schema
+  verify                        check data integrity and the image repo for missing assets
+  prune [-older-than D]         purge tombstoned rows and sync history older than D
+  status                        show schema version, synced versions and recent runs
+  config print                  show the effective configuration (secrets redacted)
+
+Settings come from flags, then environment variables, then the file given by -config
+(or CONFIG_FILE; YAML, TOML or JSON), then defaults. Run "pjsk-sync <command> -h" to list flags.
+Without a command, "sync" is assumed.
+
+exit codes:
+  0  success
+  1  error
+  2  bad command line
+  3  assets could not be written to disk (ASSET_FAIL_ON_WRITE_ERROR)
+  4  too many asset misses, including ones that are not valid images (ASSET_MAX_MISSES / ASSET_MAX_MISS_PERCENT)
+  5  a newly released card has no thumbnail (ASSET_NEW_CARD_WINDOW)
+`
+
+// 素材失败策略对应的退出码；1 是其他错误，2 是用法错误（见 usageError）
+var policyExitCodes = map[string]int{
+	sync.PolicyWriteError:       3,
+	sync.PolicyMissLimit:        4,
+	sync.PolicyNewCardThumbnail: 5,
+}
+
 func main() {
-	cfg := config.Load()
+	args := os.Args[1:]
+	cmd := "sync"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		cmd, args = args[0], args[1:]
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	var err error
+	switch cmd {
+	case "sync":
+		err = runSync(ctx, args)
+	case "migrate":
+		err = runMigrateCmd(ctx, args)
+	case "verify":
+		err = runVerify(ctx, args)
+	case "prune":
+		err = runPrune(ctx, args)
+	case "status":
+		err = runStatus(ctx, args)
+	case "config":
+		err = runConfig(args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		err = usagef("unknown command %q", cmd)
+	}
+	if err != nil {
+		log.Printf("%s: %v", cmd, err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode 区分素材失败策略；同时触发多个时取第一个（服务器顺序，其内按写盘、缺失、新卡）
+func exitCode(err error) int {
+	var ue *usageError
+	if errors.As(err, &ue) {
+		return 2
+	}
+	var failure *sync.AssetFailure
+	if errors.As(err, &failure) {
+		if code, ok := policyExitCodes[failure.Policy]; ok {
+			return code
+		}
+	}
+	return 1
+}
+
+// usageError 是命令行用法错误，与 flag 包解析失败一样以状态 2 退出
+type usageError struct{ msg string }
+
+func (e *usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...any) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// 覆盖配置项的命令行参数及其对应的配置键
+var flagKeys = map[string]string{
+	"force":             "FORCE_SYNC",
+	"dry-run":           "DRY_RUN",
+	"conditional-fetch": "CONDITIONAL_FETCH",
+	"hard-delete":       "HARD_DELETE",
+	"image-repo-dir":    "IMAGE_REPO_DIR",
+	"max-concurrency":   "MAX_CONCURRENCY",
+	"report-json":       "REPORT_JSON",
+	"report-md":         "REPORT_MARKDOWN",
+
+	"max-asset-misses":       "ASSET_MAX_MISSES",
+	"max-asset-miss-percent": "ASSET_MAX_MISS_PERCENT",
+}
+
+// loadConfig 读取配置并注册覆盖它的通用参数；调用方加上自己的参数后用 parseFlags 解析。
+// 取值的校验推迟到 parseFlags：环境变量 / 配置文件里的错误值可以用对应的参数纠正。
+func loadConfig(name string, args []string) (*config.Config, *flag.FlagSet, error) {
+	cfg, err := config.Load(configPath(args))
+	if err != nil {
+		return nil, nil, fmt.Errorf("config: %w", err)
+	}
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.String("config", "", "config file (YAML / TOML / JSON; default $CONFIG_FILE)")
+	fs.Func("regions", "comma-separated regions to sync (REGIONS)", func(v string) error {
+		cfg.SetRegions(v)
+		return nil
+	})
+	fs.BoolVar(&cfg.Force, "force", cfg.Force, "ignore versions.json and run a full sync (FORCE_SYNC)")
+	fs.BoolVar(&cfg.DryRun, "dry-run", cfg.DryRun, "report planned DB and asset changes without writing (DRY_RUN)")
+	fs.BoolVar(&cfg.ConditionalFetch, "conditional-fetch", cfg.ConditionalFetch, "use ETag / Last-Modified when fetching master (CONDITIONAL_FETCH)")
+	fs.BoolVar(&cfg.HardDelete, "hard-delete", cfg.HardDelete, "delete rows removed upstream instead of tombstoning (HARD_DELETE)")
+	fs.StringVar(&cfg.ImageRepoDir, "image-repo-dir", cfg.ImageRepoDir, "image repo checkout directory (IMAGE_REPO_DIR)")
+	fs.IntVar(&cfg.MaxConcurrency, "max-concurrency", cfg.MaxConcurrency, "parallel asset downloads (MAX_CONCURRENCY)")
+	return &cfg, fs, nil
+}
+
+func parseFlags(fs *flag.FlagSet, cfg *config.Config, args []string) error {
+	applyFlags(fs, cfg, args)
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	return nil
+}
+
+// applyFlags 解析参数并把显式给出的记为对配置的覆盖
+func applyFlags(fs *flag.FlagSet, cfg *config.Config, args []string) {
+	fs.Parse(args)
+	fs.Visit(func(f *flag.Flag) {
+		if key, ok := flagKeys[f.Name]; ok {
+			cfg.Override(key, f.Value.String())
+		}
+	})
+}
+
+// -config 要在注册其他参数（默认值取自配置）之前读出来
+func configPath(args []string) string {
+	for i, a := range args {
+		name, val, hasVal := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if a == "--" {
+			break
+		}
+		if !strings.HasPrefix(a, "-") || name != "config" {
+			continue
+		}
+		if hasVal {
+			return val
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func openDB(ctx context.Context, cfg config.Config) (*pgxpool.Pool, error) {
 	pool, err := db.Open(ctx, cfg.PostgresConnString, cfg.PGSSLMode)
 	if err != nil {
-		log.Fatalf("db open: %v", err)
+		return nil, fmt.Errorf("db open: %w", err)
 	}
-	defer pool.Close()
+	return pool, nil
+}
 
-	if err := db.Migrate(ctx, pool); err != nil {
-		log.Fatalf("db migrate: %v", err)
+// pjsk-sync sync [db|assets]
+func runSync(ctx context.Context, args []string) error {
+	var phase string
+	if len(args) > 0 && (args[0] == "db" || args[0] == "assets") {
+		phase, args = args[0], args[1:]
+	}
+	cfg, fs, err := loadConfig("sync", args)
+	if err != nil {
+		return err
+	}
+	fs.StringVar(&cfg.ReportJSON, "report-json", cfg.ReportJSON, "write the run report as JSON to this path (REPORT_JSON)")
+	fs.StringVar(&cfg.ReportMarkdown, "report-md", cfg.ReportMarkdown, "write the run report as Markdown to this path (REPORT_MARKDOWN)")
+	fs.IntVar(&cfg.AssetMaxMisses, "max-asset-misses", cfg.AssetMaxMisses, "fail when more asset downloads miss, negative for no limit (ASSET_MAX_MISSES)")
+	fs.Float64Var(&cfg.AssetMaxMissPercent, "max-asset-miss-percent", cfg.AssetMaxMissPercent, "fail when more than this percent of asset downloads miss, negative for no limit (ASSET_MAX_MISS_PERCENT)")
+	if err := parseFlags(fs, cfg, args); err != nil {
+		return err
+	}
+	phases := sync.Phases{DB: true, Assets: cfg.DownloadAssets}
+	switch phase {
+	case "db":
+		phases = sync.Phases{DB: true}
+	case "assets":
+		phases = sync.Phases{Assets: true}
+	}
+	if fs.NArg() > 0 {
+		return usagef("unexpected argument %q (want db or assets)", fs.Arg(0))
+	}
+
+	// 只跑素材时数据库是可选的：有连接串就用它跳过未变化的版本、从库里生成素材任务
+	var pool *pgxpool.Pool
+	if phases.DB || cfg.PostgresConnString != "" {
+		if pool, err = openDB(ctx, *cfg); err != nil {
+			return err
+		}
+		defer pool.Close()
 	}
 
-	if err := sync.Run(ctx, pool, cfg); err != nil {
-		log.Fatalf("sync run: %v", err)
+	// 演练不改表结构：库必须已经迁移到最新
+	if phases.DB && !cfg.DryRun {
+		if err := db.Migrate(ctx, pool); err != nil {
+			return fmt.Errorf("db migrate: %w", err)
+		}
 	}
 
+	// 报告由 Run 按 REPORT_JSON / REPORT_MARKDOWN 写出，失败时也会写
+	if _, err := sync.Run(ctx, pool, *cfg, phases); err != nil {
+		return err
+	}
 	log.Printf("done")
-}
\ No newline at end of file
+	return nil
+}
+
+// pjsk-sync migrate [up|down [N]|status]
+func runMigrateCmd(ctx context.Context, args []string) error {
+	cfg, fs, err := loadConfig("migrate", args)
+	if err != nil {
+		return err
+	}
+	if err := parseFlags(fs, cfg, args); err != nil {
+		return err
+	}
+	// 参数有误时不必连库
+	if _, _, err := parseMigrateArgs(fs.Args()); err != nil {
+		return err
+	}
+	pool, err := openDB(ctx, *cfg)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	return runMigrate(ctx, pool, fs.Args())
+}
+
+// parseMigrateArgs 解析 [up|down [N]|status]
+func parseMigrateArgs(args []string) (action string, steps int, err error) {
+	action, steps = "up", 1
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "up", "status":
+	case "down":
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return "", 0, usagef("invalid step count %q", args[1])
+			}
+			steps = n
+		}
+	default:
+		return "", 0, usagef("unknown action %q (want up, down or status)", action)
+	}
+	return action, steps, nil
+}
+
+func runMigrate(ctx context.Context, pool *pgxpool.Pool, args []string) error {
+	action, steps, err := parseMigrateArgs(args)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "up":
+		return db.Migrate(ctx, pool)
+	case "down":
+		return db.MigrateDown(ctx, pool, steps)
+	case "status":
+		states, err := db.MigrationStatus(ctx, pool)
+		if err != nil {
+			return err
+		}
+		for _, st := range states {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = st.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Printf("%4d  %-24s %s\n", st.Version, st.Name, applied)
+		}
+	}
+	return nil
+}
+
+// pjsk-sync verify：发现问题时以非零状态退出
+func runVerify(ctx context.Context, args []string) error {
+	cfg, fs, err := loadConfig("verify", args)
+	if err != nil {
+		return err
+	}
+	if err := parseFlags(fs, cfg, args); err != nil {
+		return err
+	}
+
+	pool, err := openDB(ctx, *cfg)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	res, err := sync.Verify(ctx, pool, *cfg)
+	if err != nil {
+		return err
+	}
+	if !res.OK() {
+		return fmt.Errorf("%d integrity problems, %d missing assets", res.Integrity, res.MissingAssets)
+	}
+	log.Printf("verify: ok")
+	return nil
+}
+
+// pjsk-sync prune [-older-than D]
+func runPrune(ctx context.Context, args []string) error {
+	cfg, fs, err := loadConfig("prune", args)
+	if err != nil {
+		return err
+	}
+	olderThan := fs.Duration("older-than", 30*24*time.Hour, "purge tombstones and history older than this")
+	if err := parseFlags(fs, cfg, args); err != nil {
+		return err
+	}
+
+	pool, err := openDB(ctx, *cfg)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	_, err = sync.Prune(ctx, pool, *cfg, *olderThan)
+	return err
+}
+
+// pjsk-sync status
+func runStatus(ctx context.Context, args []string) error {
+	cfg, fs, err := loadConfig("status", args)
+	if err != nil {
+		return err
+	}
+	runs := fs.Int("runs", 10, "number of recent sync runs to show")
+	if err := parseFlags(fs, cfg, args); err != nil {
+		return err
+	}
+
+	pool, err := openDB(ctx, *cfg)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	fmt.Println("schema:")
+	if err := runMigrate(ctx, pool, []string{"status"}); err != nil {
+		return err
+	}
+
+	regions, err := sync.RegionStatuses(ctx, pool)
+	if err != nil {
+		return err
+	}
+	fmt.Println("\nregions:")
+	for _, r := range regions {
+		fmt.Printf("  %-4s data=%-16s %-20s assets=%-16s %s\n",
+			r.Region, orDash(r.DataVersion), formatTime(r.DataSyncedAt), orDash(r.AssetVersion), formatTime(r.AssetsSyncedAt))
+	}
+
+	recent, err := sync.RecentRuns(ctx, pool, *runs)
+	if err != nil {
+		return err
+	}
+	fmt.Println("\nrecent runs:")
+	for _, r := range recent {
+		fmt.Printf("  #%-6d %-8s %s  %s  changes=%d", r.ID, r.Status, r.StartedAt.Format("2006-01-02 15:04:05 MST"), formatTime(r.FinishedAt), r.Changes)
+		if r.Error != "" {
+			fmt.Printf("  error=%q", r.Error)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// pjsk-sync config print
+func runConfig(args []string) error {
+	if len(args) == 0 {
+		return usagef("missing action (want print)")
+	}
+	if args[0] != "print" {
+		return usagef("unknown action %q (want print)", args[0])
+	}
+	cfg, fs, err := loadConfig("config print", args[1:])
+	if err != nil {
+		return err
+	}
+	// 不合法的配置也照样打印，错误随后报告
+	applyFlags(fs, cfg, args[1:])
+
+	for _, st := range cfg.Settings {
+		v := strings.ReplaceAll(st.Redacted(), "\n", "\n"+strings.Repeat(" ", 48))
+		fmt.Printf("%-36s %-10s %s\n", st.Key, st.Origin, v)
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04:05 MST")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
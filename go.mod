@@ -3,9 +3,11 @@ module pjsk-sync
 go 1.24.0
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/HugoSmits86/nativewebp v1.2.1
 	github.com/jackc/pgx/v5 v5.7.1
 	golang.org/x/image v0.34.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
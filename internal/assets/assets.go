@@ -8,29 +8,27 @@ import (
 	_ "image/jpeg" // Added for JPEG decoder registration
 	_ "image/png"  // Added for PNG decoder registration
 	"io"
-	"net/http"
-	"time"
+	"path"
+	"strconv"
+	"strings"
 
 	"github.com/HugoSmits86/nativewebp"
 	_ "golang.org/x/image/webp" // Added for WebP decoder registration
+
+	"pjsk-sync/internal/config"
+	"pjsk-sync/internal/httpx"
 )
 
 type Downloader struct {
-	http *http.Client
+	http *httpx.Client
 }
 
-func NewDownloader() *Downloader {
-	return &Downloader{http: &http.Client{Timeout: 60 * time.Second}}
+func NewDownloader(c *httpx.Client) *Downloader {
+	return &Downloader{http: c}
 }
 
 func (d *Downloader) Get(ctx context.Context, url string) ([]byte, int, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, 0, err
-	}
-	req.Header.Set("User-Agent", "pjsk-sync-action")
-
-	resp, err := d.http.Do(req)
+	resp, err := d.http.Get(ctx, url, nil)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -73,39 +71,49 @@ func ConvertToWebP(data []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-const BaseURL = "https://assets.unipjsk.com"
-
-func CardNormalURLJP(assetbundle string) string {
-	return fmt.Sprintf("%s/startapp/thumbnail/chara/%s_normal.png", BaseURL, assetbundle)
+// Source 按服务器拼接素材 URL，路径模板见 config.AssetSource
+type Source struct {
+	cfg config.AssetSource
 }
-func CardAfterTrainingURLJP(assetbundle string) string {
-	return fmt.Sprintf("%s/startapp/thumbnail/chara/%s_after_training.png", BaseURL, assetbundle)
+
+func NewSource(cfg config.AssetSource) Source {
+	return Source{cfg: cfg}
 }
 
-func EventLogoURLCN(eventAssetbundle string) string {
-	return fmt.Sprintf("%s/ondemand/event/%s/logo/logo.png", BaseURL, eventAssetbundle)
+func (s Source) Region() string { return s.cfg.Region }
+
+// DestPath 把相对路径放到该服务器的子目录下（默认服务器沿用根目录）
+func (s Source) DestPath(rel string) string {
+	if s.cfg.DestDir == "" {
+		return rel
+	}
+	return path.Join(s.cfg.DestDir, rel)
 }
-func EventBgURLCN(eventAssetbundle string) string {
-	return fmt.Sprintf("%s/ondemand/event/%s/screen/bg.png", BaseURL, eventAssetbundle)
+
+func (s Source) build(tmpl, assetbundle string, id int) string {
+	p := strings.NewReplacer("{assetbundle}", assetbundle, "{id}", strconv.Itoa(id)).Replace(tmpl)
+	return strings.TrimSuffix(s.cfg.BaseURL, "/") + p
 }
-func EventLogoURLJP(eventAssetbundle string) string {
-	return fmt.Sprintf("%s/ondemand/event/%s/logo/logo.png", BaseURL, eventAssetbundle)
+
+func (s Source) CardNormalURL(assetbundle string) string {
+	return s.build(s.cfg.CardNormalPath, assetbundle, 0)
 }
-func EventBgURLJP(eventAssetbundle string) string {
-	return fmt.Sprintf("%s/ondemand/event/%s/screen/bg.png", BaseURL, eventAssetbundle)
+func (s Source) CardAfterTrainingURL(assetbundle string) string {
+	return s.build(s.cfg.CardAfterTrainingPath, assetbundle, 0)
 }
 
-func GachaBannerURLCN(gachaID int) string {
-	return fmt.Sprintf("%s/startapp/home/banner/banner_gacha%d/banner_gacha%d.png", BaseURL, gachaID, gachaID)
+func (s Source) EventLogoURL(eventAssetbundle string) string {
+	return s.build(s.cfg.EventLogoPath, eventAssetbundle, 0)
 }
-func GachaBannerURLJP(gachaID int) string {
-	return fmt.Sprintf("%s/startapp/home/banner/banner_gacha%d/banner_gacha%d.png", BaseURL, gachaID, gachaID)
+func (s Source) EventBgURL(eventAssetbundle string) string {
+	return s.build(s.cfg.EventBgPath, eventAssetbundle, 0)
 }
 
-// Gacha logo as fallback when banner is not available
-func GachaLogoURLCN(gachaID int) string {
-	return fmt.Sprintf("%s/ondemand/gacha/ab_gacha_%d/logo/logo.png", BaseURL, gachaID)
+func (s Source) GachaBannerURL(gachaID int) string {
+	return s.build(s.cfg.GachaBannerPath, "", gachaID)
 }
-func GachaLogoURLJP(gachaID int) string {
-	return fmt.Sprintf("%s/ondemand/gacha/ab_gacha_%d/logo/logo.png", BaseURL, gachaID)
+
+// Gacha logo as fallback when banner is not available
+func (s Source) GachaLogoURL(gachaID int) string {
+	return s.build(s.cfg.GachaLogoPath, "", gachaID)
 }
@@ -1,67 +1,441 @@
 package config
 
 import (
+	"errors"
+	"maps"
+	"net/http"
+	"net/url"
 	"os"
-	"strconv"
+	"slices"
+	"strings"
+	"time"
+
+	"pjsk-sync/internal/httpx"
 )
 
-type Config struct {
-	PostgresConnString string
-	PGSSLMode          string // require / verify-full 等（可选：自动补进 URL DSN）
+// DefaultRegion 是历史上唯一的服务器：旧的不带前缀的 *_URL 环境变量归它所有
+const DefaultRegion = "cn"
+
+// RegionSource 是单个服务器（jp / en / tw / kr / cn）的 master 数据来源
+type RegionSource struct {
+	Region string
+
+	// 可选：versions.json，dataVersion / assetVersion 未变时跳过该服务器的落库 / 素材
+	VersionURL string
 
 	GachasURL string
 	CardsURL  string
 	EventsURL string
 
+	EventCardsURL       string
+	EventDeckBonusesURL string
+
+	MusicsURL            string
+	MusicDifficultiesURL string
+
+	GameCharactersURL string
+	UnitProfilesURL   string
+
+	Assets AssetSource
+}
+
+// AssetSource 描述一个服务器的素材站：BaseURL + 各类素材的路径模板。
+// 模板占位符：{assetbundle} 为 assetbundleName，{id} 为卡池 id。
+type AssetSource struct {
+	Region  string
+	BaseURL string
+	DestDir string // 相对 IMAGE_REPO_DIR 的子目录；默认服务器为空以兼容旧路径
+
+	CardNormalPath        string
+	CardAfterTrainingPath string
+	EventLogoPath         string
+	EventBgPath           string
+	GachaBannerPath       string
+	GachaLogoPath         string
+}
+
+type Config struct {
+	PostgresConnString string
+	PGSSLMode          string // require / verify-full 等（可选：自动补进 URL DSN）
+
+	Regions []RegionSource // REGIONS 中启用的服务器，按声明顺序同步
+
+	// 本服素材缺失时依次尝试的服务器（可以不在 REGIONS 中）
+	AssetFallbacks []AssetSource
+
+	// 忽略 versions.json 比对，强制完整同步（也可用 --force）
+	Force bool
+
+	// 演练：落库阶段在只读事务里与正式表对比，只打印计划的改动；素材只列出任务，不下载不写盘
+	DryRun bool
+
+	// 带 ETag / Last-Modified 条件拉取 master，304 的数据组跳过落库
+	ConditionalFetch bool
+
+	// 单个 master 文件中不合法记录的比例（百分比）超过该值时整次同步失败
+	MaxInvalidPercent float64
+	// 被隔离记录的 JSON 报告路径，空则只打日志
+	QuarantineReport string
+
+	// 运行报告（sync.Report）的 JSON / Markdown 路径，空则不写；Markdown 可直接追加到 GITHUB_STEP_SUMMARY
+	ReportJSON     string
+	ReportMarkdown string
+
+	// 同步锁被其他进程持有时：true 排队等待（最多 LockWaitTimeout，0 为不限），false 立即失败
+	LockWait        bool
+	LockWaitTimeout time.Duration
+
+	// upstream 撤下的记录：默认只打 deleted_at，开启后直接删除
+	HardDelete bool
+
+	// master / 素材共用的 HTTP 客户端（代理、超时、按 host 的请求头、重试）
+	HTTP httpx.Options
+
 	DownloadAssets bool
 	ImageRepoDir   string // 图床仓库被 checkout 到哪个目录
 	MaxConcurrency int
+
+	// 素材失败策略，任一触发时同步以对应的退出码失败（见 sync.AssetFailure）：
+	// 素材写盘失败；缺失数或缺失比例（相对需要下载的任务，转不成 webp 的也算缺失）超限，负数不限；
+	// 发布时间在 AssetNewCardWindow 内的卡面缺缩略图，0 关闭
+	AssetFailOnWriteError bool
+	AssetMaxMisses        int
+	AssetMaxMissPercent   float64
+	AssetNewCardWindow    time.Duration
+
+	// 每一项生效的配置及其来源，按键排序（config print 用）
+	Settings []Setting
+
+	loader *loader
+}
+
+// 各服务器 master 仓库的默认根目录（raw.githubusercontent.com 以获取纯文本 JSON）
+// 可通过 <REGION>_MASTER_BASE_URL 覆盖，单个文件可通过 <REGION>_<ENTITY>_URL 覆盖
+var defaultMasterBase = map[string]string{
+	"jp": "https://raw.githubusercontent.com/Team-Haruki/haruki-sekai-master/main/master",
+	"en": "https://raw.githubusercontent.com/Sekai-World/sekai-master-db-en-diff/main",
+	"tw": "https://raw.githubusercontent.com/Sekai-World/sekai-master-db-tc-diff/main",
+	"kr": "https://raw.githubusercontent.com/Sekai-World/sekai-master-db-kr-diff/main",
+	// 修改了默认源至 kotori8823/sekai-sc-master-db
+	"cn": "https://raw.githubusercontent.com/kotori8823/sekai-sc-master-db/master",
+}
+
+// 个别文件不走该服务器的默认仓库
+var defaultMasterOverrides = map[string]map[string]string{
+	// 国服卡面沿用日服 master（国服仓库的 cards.json 更新滞后）
+	"cn": {"cards.json": "https://raw.githubusercontent.com/Team-Haruki/haruki-sekai-master/main/master/cards.json"},
+}
+
+// unipjsk 的目录结构（startapp / ondemand）
+var unipjskAssetPaths = AssetSource{
+	CardNormalPath:        "/startapp/thumbnail/chara/{assetbundle}_normal.png",
+	CardAfterTrainingPath: "/startapp/thumbnail/chara/{assetbundle}_after_training.png",
+	EventLogoPath:         "/ondemand/event/{assetbundle}/logo/logo.png",
+	EventBgPath:           "/ondemand/event/{assetbundle}/screen/bg.png",
+	GachaBannerPath:       "/startapp/home/banner/banner_gacha{id}/banner_gacha{id}.png",
+	GachaLogoPath:         "/ondemand/gacha/ab_gacha_{id}/logo/logo.png",
+}
+
+// sekai.best 解包站的目录结构（*_rip）
+var sekaiBestAssetPaths = AssetSource{
+	CardNormalPath:        "/thumbnail/chara_rip/{assetbundle}_normal.png",
+	CardAfterTrainingPath: "/thumbnail/chara_rip/{assetbundle}_after_training.png",
+	EventLogoPath:         "/event/{assetbundle}/logo_rip/logo.png",
+	EventBgPath:           "/event/{assetbundle}/screen_rip/bg.png",
+	GachaBannerPath:       "/home/banner/banner_gacha{id}_rip/banner_gacha{id}.png",
+	GachaLogoPath:         "/gacha/ab_gacha_{id}/logo_rip/logo.png",
 }
 
-func Load() Config {
-	return Config{
-		PostgresConnString: os.Getenv("POSTGRES_CONNECTION_STRING"),
-		PGSSLMode:          getenv("PG_SSLMODE", "require"),
+var defaultAssetBase = map[string]string{
+	"jp": "https://assets.unipjsk.com",
+	"en": "https://storage.sekai.best/sekai-en-assets",
+	"tw": "https://storage.sekai.best/sekai-tc-assets",
+	"kr": "https://storage.sekai.best/sekai-kr-assets",
+	"cn": "https://storage.sekai.best/sekai-cn-assets",
+}
+
+// Load 按 环境变量 > 配置文件 > 默认值 读取配置。path 为空时使用 CONFIG_FILE（也为空则不读文件）。
+// 只有配置文件本身读不了时返回错误；无法解析或不合法的值由 Validate 全部列出（而不是悄悄回退到默认值），
+// 这样命令行参数还有机会覆盖它们。
+func Load(path string) (Config, error) {
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	var file map[string]string
+	if path != "" {
+		var err error
+		if file, err = loadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+	l := newLoader(file, path)
 
-		// 修改了默认源至 kotori8823/sekai-sc-master-db
-		// 注意：此处使用了 raw.githubusercontent.com 以获取纯文本 JSON
-		GachasURL: getenv("GACHAS_URL", "https://raw.githubusercontent.com/kotori8823/sekai-sc-master-db/master/gachas.json"),
-		CardsURL:  getenv("CARDS_URL", "https://raw.githubusercontent.com/Team-Haruki/haruki-sekai-master/main/master/cards.json"),
-		EventsURL: getenv("EVENTS_URL", "https://raw.githubusercontent.com/kotori8823/sekai-sc-master-db/master/events.json"),
+	regions := l.regions(l.str("REGIONS", DefaultRegion))
 
-		DownloadAssets: getenvBool("DOWNLOAD_ASSETS", true),
-		ImageRepoDir:   getenv("IMAGE_REPO_DIR", "image-hosting"),
-		MaxConcurrency: getenvInt("MAX_CONCURRENCY", 6),
+	var fallbacks []AssetSource
+	for _, r := range splitList(l.str("ASSET_FALLBACK_REGIONS", "jp")) {
+		fallbacks = append(fallbacks, l.assetSource(r))
 	}
+
+	h := httpx.DefaultOptions()
+
+	cfg := Config{
+		PostgresConnString: l.str("POSTGRES_CONNECTION_STRING", ""),
+		PGSSLMode:          l.oneOf("PG_SSLMODE", "require", "disable", "allow", "prefer", "require", "verify-ca", "verify-full"),
+
+		Regions:        regions,
+		AssetFallbacks: fallbacks,
+
+		Force: l.boolean("FORCE_SYNC", false),
+
+		DryRun: l.boolean("DRY_RUN", false),
+
+		ConditionalFetch: l.boolean("CONDITIONAL_FETCH", true),
+
+		MaxInvalidPercent: l.float("MAX_INVALID_PERCENT", 5),
+		QuarantineReport:  l.str("QUARANTINE_REPORT", ""),
+		ReportJSON:        l.str("REPORT_JSON", ""),
+		ReportMarkdown:    l.str("REPORT_MARKDOWN", ""),
+
+		LockWait:        l.oneOf("LOCK_MODE", "wait", "wait", "fail") == "wait",
+		LockWaitTimeout: l.duration("LOCK_WAIT_TIMEOUT", 10*time.Minute),
+
+		HardDelete: l.boolean("HARD_DELETE", false),
+
+		HTTP: httpx.Options{
+			ProxyURL:    l.str("HTTP_PROXY_URL", ""),
+			UserAgent:   l.str("HTTP_USER_AGENT", h.UserAgent),
+			HostHeaders: l.hostHeaders("HTTP_HOST_HEADERS"),
+
+			ConnectTimeout: l.duration("HTTP_CONNECT_TIMEOUT", h.ConnectTimeout),
+			ReadTimeout:    l.duration("HTTP_READ_TIMEOUT", h.ReadTimeout),
+			Timeout:        l.duration("HTTP_TIMEOUT", h.Timeout),
+
+			MaxIdleConns:        l.integer("HTTP_MAX_IDLE_CONNS", h.MaxIdleConns),
+			MaxIdleConnsPerHost: l.integer("HTTP_MAX_IDLE_CONNS_PER_HOST", h.MaxIdleConnsPerHost),
+			HTTP2:               l.boolean("HTTP_HTTP2", h.HTTP2),
+
+			Retry: httpx.RetryPolicy{
+				MaxAttempts: l.integer("HTTP_RETRY_MAX_ATTEMPTS", h.Retry.MaxAttempts),
+				BaseDelay:   l.duration("HTTP_RETRY_BASE_DELAY", h.Retry.BaseDelay),
+				MaxDelay:    l.duration("HTTP_RETRY_MAX_DELAY", h.Retry.MaxDelay),
+				RetryOn:     l.integers("HTTP_RETRY_STATUSES", h.Retry.RetryOn),
+			},
+		},
+
+		DownloadAssets: l.boolean("DOWNLOAD_ASSETS", true),
+		ImageRepoDir:   l.str("IMAGE_REPO_DIR", "image-hosting"),
+		MaxConcurrency: l.integer("MAX_CONCURRENCY", 6),
+
+		AssetFailOnWriteError: l.boolean("ASSET_FAIL_ON_WRITE_ERROR", true),
+		AssetMaxMisses:        l.integer("ASSET_MAX_MISSES", -1),
+		AssetMaxMissPercent:   l.float("ASSET_MAX_MISS_PERCENT", -1),
+		AssetNewCardWindow:    l.duration("ASSET_NEW_CARD_WINDOW", 0),
+	}
+	cfg.Settings = l.sortedSettings()
+	cfg.loader = l
+	return cfg, nil
 }
 
-func getenv(k, def string) string {
-	if v := os.Getenv(k); v != "" {
-		return v
+// validate 检查各项取值之间的约束（类型错误已在读取时记录）
+func (l *loader) validate(cfg Config) {
+	l.check(len(cfg.Regions) > 0, "REGIONS", "no regions enabled")
+	for _, r := range cfg.Regions {
+		up := strings.ToUpper(r.Region)
+		var missing []string
+		for _, f := range []struct{ key, url string }{
+			{"GACHAS_URL", r.GachasURL}, {"CARDS_URL", r.CardsURL}, {"EVENTS_URL", r.EventsURL},
+			{"EVENT_CARDS_URL", r.EventCardsURL}, {"EVENT_DECK_BONUSES_URL", r.EventDeckBonusesURL},
+			{"MUSICS_URL", r.MusicsURL}, {"MUSIC_DIFFICULTIES_URL", r.MusicDifficultiesURL},
+			{"GAME_CHARACTERS_URL", r.GameCharactersURL}, {"UNIT_PROFILES_URL", r.UnitProfilesURL},
+		} {
+			if f.url == "" {
+				missing = append(missing, up+"_"+f.key)
+			}
+		}
+		l.check(len(missing) == 0, up+"_MASTER_BASE_URL", "no master source for region %s (set %s_MASTER_BASE_URL or %s_MASTER_DIR; missing %s)",
+			r.Region, up, up, strings.Join(missing, ", "))
+		if cfg.DownloadAssets {
+			l.check(r.Assets.BaseURL != "", up+"_ASSET_BASE_URL", "no asset site for region %s", r.Region)
+		}
+	}
+
+	l.check(cfg.MaxInvalidPercent >= 0 && cfg.MaxInvalidPercent <= 100, "MAX_INVALID_PERCENT", "must be between 0 and 100")
+	l.check(cfg.LockWaitTimeout >= 0, "LOCK_WAIT_TIMEOUT", "must not be negative")
+	l.check(cfg.MaxConcurrency >= 1, "MAX_CONCURRENCY", "must be at least 1")
+	l.check(cfg.AssetMaxMissPercent <= 100, "ASSET_MAX_MISS_PERCENT", "must not exceed 100")
+	l.check(cfg.AssetNewCardWindow >= 0, "ASSET_NEW_CARD_WINDOW", "must not be negative")
+	l.check(!cfg.DownloadAssets || cfg.ImageRepoDir != "", "IMAGE_REPO_DIR", "required when DOWNLOAD_ASSETS is on")
+
+	h := cfg.HTTP
+	if h.ProxyURL != "" {
+		u, err := url.Parse(h.ProxyURL)
+		l.check(err == nil && u.Scheme != "" && u.Host != "", "HTTP_PROXY_URL", "not an absolute URL")
+	}
+	l.check(h.ConnectTimeout >= 0, "HTTP_CONNECT_TIMEOUT", "must not be negative")
+	l.check(h.ReadTimeout >= 0, "HTTP_READ_TIMEOUT", "must not be negative")
+	l.check(h.Timeout >= 0, "HTTP_TIMEOUT", "must not be negative")
+	l.check(h.MaxIdleConns >= 0, "HTTP_MAX_IDLE_CONNS", "must not be negative")
+	l.check(h.MaxIdleConnsPerHost >= 0, "HTTP_MAX_IDLE_CONNS_PER_HOST", "must not be negative")
+	l.check(h.Retry.MaxAttempts >= 1, "HTTP_RETRY_MAX_ATTEMPTS", "must be at least 1")
+	l.check(h.Retry.BaseDelay >= 0, "HTTP_RETRY_BASE_DELAY", "must not be negative")
+	l.check(h.Retry.MaxDelay >= 0, "HTTP_RETRY_MAX_DELAY", "must not be negative")
+	for _, st := range h.Retry.RetryOn {
+		l.check(st >= 100 && st <= 599, "HTTP_RETRY_STATUSES", "%d is not an HTTP status", st)
 	}
-	return def
 }
 
-func getenvBool(k string, def bool) bool {
-	v := os.Getenv(k)
-	if v == "" {
-		return def
+// SetRegions 用与 Load 相同的来源（环境变量 / 配置文件）重新读取服务器列表，供命令行参数覆盖 REGIONS
+func (c *Config) SetRegions(list string) {
+	c.Regions = c.loader.regions(list)
+	c.Override("REGIONS", list)
+}
+
+// Override 记录命令行参数对某项配置的覆盖，体现在 Settings 和校验信息里
+func (c *Config) Override(key, value string) {
+	delete(c.loader.invalid, key)
+	c.loader.record(key, value, "flag")
+	c.Settings = c.loader.sortedSettings()
+}
+
+// Validate 报告读取时无法解析的值和各项取值之间的约束（命令行参数覆盖之后调用）
+func (c *Config) Validate() error {
+	l := c.loader
+	l.errs = nil
+	l.validate(*c)
+	var errs []error
+	for _, k := range slices.Sorted(maps.Keys(l.invalid)) {
+		errs = append(errs, l.invalid[k])
 	}
-	b, err := strconv.ParseBool(v)
-	if err != nil {
-		return def
+	return errors.Join(append(errs, l.errs...)...)
+}
+
+func (l *loader) regions(list string) []RegionSource {
+	var regions []RegionSource
+	for _, r := range splitList(list) {
+		regions = append(regions, l.region(r))
 	}
-	return b
+	return regions
 }
 
-func getenvInt(k string, def int) int {
-	v := os.Getenv(k)
-	if v == "" {
-		return def
+func (l *loader) region(region string) RegionSource {
+	base := l.str(strings.ToUpper(region)+"_MASTER_BASE_URL", defaultMasterBase[region])
+	// 目录模式：指向本地 checkout 的 master 仓库，所有文件按文件名解析
+	if dir := l.regionEnv(region, "MASTER_DIR"); dir != "" {
+		base = dir
 	}
-	i, err := strconv.Atoi(v)
-	if err != nil {
-		return def
+	return RegionSource{
+		Region: region,
+
+		VersionURL: l.regionEnv(region, "VERSION_URL"),
+
+		GachasURL: l.regionURL(region, base, "GACHAS_URL", "gachas.json"),
+		CardsURL:  l.regionURL(region, base, "CARDS_URL", "cards.json"),
+		EventsURL: l.regionURL(region, base, "EVENTS_URL", "events.json"),
+
+		EventCardsURL:       l.regionURL(region, base, "EVENT_CARDS_URL", "eventCards.json"),
+		EventDeckBonusesURL: l.regionURL(region, base, "EVENT_DECK_BONUSES_URL", "eventDeckBonuses.json"),
+
+		MusicsURL:            l.regionURL(region, base, "MUSICS_URL", "musics.json"),
+		MusicDifficultiesURL: l.regionURL(region, base, "MUSIC_DIFFICULTIES_URL", "musicDifficulties.json"),
+
+		GameCharactersURL: l.regionURL(region, base, "GAME_CHARACTERS_URL", "gameCharacters.json"),
+		UnitProfilesURL:   l.regionURL(region, base, "UNIT_PROFILES_URL", "unitProfiles.json"),
+
+		Assets: l.assetSource(region),
+	}
+}
+
+// 键：<REGION>_ASSET_BASE_URL / <REGION>_ASSET_DIR / <REGION>_ASSET_<KIND>_PATH
+func (l *loader) assetSource(region string) AssetSource {
+	up := strings.ToUpper(region)
+
+	paths := sekaiBestAssetPaths
+	if region == "jp" {
+		paths = unipjskAssetPaths
+	}
+	destDir := region
+	if region == DefaultRegion {
+		destDir = ""
+	}
+
+	return AssetSource{
+		Region:  region,
+		BaseURL: l.str(up+"_ASSET_BASE_URL", defaultAssetBase[region]),
+		DestDir: l.str(up+"_ASSET_DIR", destDir),
+
+		CardNormalPath:        l.str(up+"_ASSET_CARD_NORMAL_PATH", paths.CardNormalPath),
+		CardAfterTrainingPath: l.str(up+"_ASSET_CARD_AFTER_TRAINING_PATH", paths.CardAfterTrainingPath),
+		EventLogoPath:         l.str(up+"_ASSET_EVENT_LOGO_PATH", paths.EventLogoPath),
+		EventBgPath:           l.str(up+"_ASSET_EVENT_BG_PATH", paths.EventBgPath),
+		GachaBannerPath:       l.str(up+"_ASSET_GACHA_BANNER_PATH", paths.GachaBannerPath),
+		GachaLogoPath:         l.str(up+"_ASSET_GACHA_LOGO_PATH", paths.GachaLogoPath),
+	}
+}
+
+// 优先级：<REGION>_<KEY> > <KEY>（仅默认服务器，兼容旧配置）> 内置覆盖 > base/file
+// 任何一级都可以是 file:// 或本地路径
+func (l *loader) regionURL(region, base, key, file string) string {
+	u := l.regionEnv(region, key)
+	switch {
+	case u != "":
+		return u
+	// 内置覆盖只针对内置的默认仓库：自定义的镜像 / 本地 master 自成一套
+	case defaultMasterOverrides[region][file] != "" && base == defaultMasterBase[region]:
+		u = defaultMasterOverrides[region][file]
+	case base != "":
+		u = strings.TrimSuffix(base, "/") + "/" + file
+	}
+	l.record(strings.ToUpper(region)+"_"+key, u, "")
+	return u
+}
+
+func (l *loader) regionEnv(region, key string) string {
+	k := strings.ToUpper(region) + "_" + key
+	v, origin := l.lookup(k)
+	if origin == "" && region == DefaultRegion {
+		v, origin = l.lookup(key)
+	}
+	l.record(k, v, origin)
+	return v
+}
+
+// HTTP_HOST_HEADERS 每行一条 "<host> <Header>: <value>"，例如
+//
+//	storage.example.com Authorization: Bearer xxx
+//
+// 空行和 # 开头的行忽略；同一 host 可以写多行
+func (l *loader) hostHeaders(k string) map[string]http.Header {
+	v := l.str(k, "")
+	out := map[string]http.Header{}
+	for _, line := range strings.Split(v, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		host, kv, ok := strings.Cut(line, " ")
+		name, val, ok2 := strings.Cut(kv, ":")
+		if !ok || !ok2 || strings.TrimSpace(name) == "" {
+			l.check(false, k, "malformed line %q (want \"<host> <Header>: <value>\")", line)
+			continue
+		}
+		host = strings.ToLower(host)
+		if out[host] == nil {
+			out[host] = http.Header{}
+		}
+		out[host].Add(strings.TrimSpace(name), strings.TrimSpace(val))
+	}
+	return out
+}
+
+// 逗号分隔的服务器列表，统一小写、去空项
+func splitList(v string) []string {
+	var out []string
+	for _, r := range strings.Split(v, ",") {
+		r = strings.ToLower(strings.TrimSpace(r))
+		if r != "" {
+			out = append(out, r)
+		}
 	}
-	return i
+	return out
 }
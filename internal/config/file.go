@@ -0,0 +1,174 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// 配置文件的键就是小写的环境变量名，嵌套的表用 _ 连接，例如
+//
+//	max_concurrency: 6
+//	http:
+//	  retry:
+//	    max_attempts: 5        # HTTP_RETRY_MAX_ATTEMPTS
+//	  host_headers:            # HTTP_HOST_HEADERS
+//	    storage.example.com:
+//	      Authorization: Bearer xxx
+//	jp:
+//	  master_dir: ./master-jp  # JP_MASTER_DIR
+//
+// 列表写成数组或逗号分隔的字符串均可。环境变量优先于配置文件。
+func loadFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &doc)
+	case ".toml":
+		err = toml.Unmarshal(b, &doc)
+	case ".json":
+		err = json.Unmarshal(b, &doc)
+	default:
+		return nil, fmt.Errorf("%s: unsupported config format (want .yaml, .yml, .toml or .json)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	out := map[string]string{}
+	if err := flatten("", doc, out); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	regions := knownRegions(out)
+	var unknown []string
+	for k := range out {
+		if !knownKey(k, regions) {
+			unknown = append(unknown, strings.ToLower(k))
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("%s: unknown keys: %s", path, strings.Join(unknown, ", "))
+	}
+	return out, nil
+}
+
+func flatten(prefix string, v any, out map[string]string) error {
+	switch v := v.(type) {
+	case map[string]any:
+		if prefix == "HTTP_HOST_HEADERS" {
+			return flattenHostHeaders(v, out)
+		}
+		for k, child := range v {
+			key := strings.ToUpper(k)
+			if prefix != "" {
+				key = prefix + "_" + key
+			}
+			if err := flatten(key, child, out); err != nil {
+				return err
+			}
+		}
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, it := range v {
+			switch it.(type) {
+			case map[string]any, []any:
+				return fmt.Errorf("%s: list items must be scalars", strings.ToLower(prefix))
+			}
+			items = append(items, fmt.Sprint(it))
+		}
+		out[prefix] = strings.Join(items, ",")
+	case nil:
+	default:
+		if prefix == "" {
+			return fmt.Errorf("top level must be a table")
+		}
+		out[prefix] = fmt.Sprint(v)
+	}
+	return nil
+}
+
+// host_headers 展平成 HTTP_HOST_HEADERS 的多行格式
+func flattenHostHeaders(hosts map[string]any, out map[string]string) error {
+	var lines []string
+	for host, hv := range hosts {
+		headers, ok := hv.(map[string]any)
+		if !ok {
+			return fmt.Errorf("http_host_headers.%s: want a table of header: value", host)
+		}
+		for name, val := range headers {
+			lines = append(lines, fmt.Sprintf("%s %s: %v", host, name, val))
+		}
+	}
+	sort.Strings(lines)
+	out["HTTP_HOST_HEADERS"] = strings.Join(lines, "\n")
+	return nil
+}
+
+var globalKeys = map[string]bool{
+	"POSTGRES_CONNECTION_STRING": true, "PG_SSLMODE": true,
+	"REGIONS": true, "ASSET_FALLBACK_REGIONS": true,
+	"FORCE_SYNC": true, "DRY_RUN": true, "CONDITIONAL_FETCH": true,
+	"MAX_INVALID_PERCENT": true, "QUARANTINE_REPORT": true,
+	"REPORT_JSON": true, "REPORT_MARKDOWN": true,
+	"LOCK_MODE": true, "LOCK_WAIT_TIMEOUT": true,
+	"HARD_DELETE":    true,
+	"HTTP_PROXY_URL": true, "HTTP_USER_AGENT": true, "HTTP_HOST_HEADERS": true,
+	"HTTP_CONNECT_TIMEOUT": true, "HTTP_READ_TIMEOUT": true, "HTTP_TIMEOUT": true,
+	"HTTP_MAX_IDLE_CONNS": true, "HTTP_MAX_IDLE_CONNS_PER_HOST": true, "HTTP_HTTP2": true,
+	"HTTP_RETRY_MAX_ATTEMPTS": true, "HTTP_RETRY_BASE_DELAY": true, "HTTP_RETRY_MAX_DELAY": true, "HTTP_RETRY_STATUSES": true,
+	"DOWNLOAD_ASSETS": true, "IMAGE_REPO_DIR": true, "MAX_CONCURRENCY": true,
+	"ASSET_FAIL_ON_WRITE_ERROR": true, "ASSET_MAX_MISSES": true, "ASSET_MAX_MISS_PERCENT": true, "ASSET_NEW_CARD_WINDOW": true,
+}
+
+// <REGION>_ 前缀的键；默认服务器还可以省略前缀（兼容旧配置）
+var regionKeys = map[string]bool{
+	"MASTER_BASE_URL": true, "MASTER_DIR": true, "VERSION_URL": true,
+	"GACHAS_URL": true, "CARDS_URL": true, "EVENTS_URL": true,
+	"EVENT_CARDS_URL": true, "EVENT_DECK_BONUSES_URL": true,
+	"MUSICS_URL": true, "MUSIC_DIFFICULTIES_URL": true,
+	"GAME_CHARACTERS_URL": true, "UNIT_PROFILES_URL": true,
+	"ASSET_BASE_URL": true, "ASSET_DIR": true,
+	"ASSET_CARD_NORMAL_PATH": true, "ASSET_CARD_AFTER_TRAINING_PATH": true,
+	"ASSET_EVENT_LOGO_PATH": true, "ASSET_EVENT_BG_PATH": true,
+	"ASSET_GACHA_BANNER_PATH": true, "ASSET_GACHA_LOGO_PATH": true,
+}
+
+// knownRegions 是可以出现在键前缀里的服务器（大写）：内置的，加上配置文件或环境变量的
+// REGIONS / ASSET_FALLBACK_REGIONS 里列出的自定义服务器
+func knownRegions(file map[string]string) map[string]bool {
+	out := map[string]bool{}
+	for r := range defaultMasterBase {
+		out[strings.ToUpper(r)] = true
+	}
+	for _, k := range []string{"REGIONS", "ASSET_FALLBACK_REGIONS"} {
+		for _, list := range []string{file[k], os.Getenv(k)} {
+			for _, r := range splitList(list) {
+				out[strings.ToUpper(r)] = true
+			}
+		}
+	}
+	return out
+}
+
+func knownKey(k string, regions map[string]bool) bool {
+	if globalKeys[k] {
+		return true
+	}
+	if regionKeys[k] {
+		return k != "MASTER_BASE_URL" && !strings.HasPrefix(k, "ASSET_")
+	}
+	region, rest, ok := strings.Cut(k, "_")
+	return ok && regions[region] && regionKeys[rest]
+}
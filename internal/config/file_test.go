@@ -0,0 +1,68 @@
+package config
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestFlatten(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     any
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "scalars",
+			doc:  map[string]any{"max_concurrency": 6, "dry_run": true, "image_repo_dir": "img"},
+			want: map[string]string{"MAX_CONCURRENCY": "6", "DRY_RUN": "true", "IMAGE_REPO_DIR": "img"},
+		},
+		{
+			name: "nested tables",
+			doc: map[string]any{
+				"http": map[string]any{"retry": map[string]any{"max_attempts": 5}},
+				"jp":   map[string]any{"master_dir": "./master-jp"},
+			},
+			want: map[string]string{"HTTP_RETRY_MAX_ATTEMPTS": "5", "JP_MASTER_DIR": "./master-jp"},
+		},
+		{
+			name: "lists",
+			doc:  map[string]any{"regions": []any{"cn", "jp"}, "http_retry_statuses": []any{429, 503}},
+			want: map[string]string{"REGIONS": "cn,jp", "HTTP_RETRY_STATUSES": "429,503"},
+		},
+		{
+			name: "null is skipped",
+			doc:  map[string]any{"regions": nil},
+			want: map[string]string{},
+		},
+		{
+			name: "host headers",
+			doc: map[string]any{"http": map[string]any{"host_headers": map[string]any{
+				"b.example": map[string]any{"Authorization": "Bearer b"},
+				"a.example": map[string]any{"X-Token": "a"},
+			}}},
+			want: map[string]string{"HTTP_HOST_HEADERS": "a.example X-Token: a\nb.example Authorization: Bearer b"},
+		},
+		{name: "host headers not a table", doc: map[string]any{"http_host_headers": map[string]any{"a.example": "x"}}, wantErr: true},
+		{name: "nested list", doc: map[string]any{"regions": []any{[]any{"cn"}}}, wantErr: true},
+		{name: "top level scalar", doc: "cn", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := map[string]string{}
+			err := flatten("", tt.doc, out)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("flatten = %v, want error", out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(out, tt.want) {
+				t.Fatalf("flatten = %v, want %v", out, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Setting 是一项生效的配置及其来源（env / 配置文件路径 / default）
+type Setting struct {
+	Key    string
+	Value  string
+	Origin string
+}
+
+// loader 按 环境变量 > 配置文件 > 默认值 取值；解析失败的值记为错误而不是悄悄回退到默认值
+type loader struct {
+	file     map[string]string // 配置文件展平后的 KEY -> 值
+	filePath string
+
+	settings map[string]Setting
+	invalid  map[string]error // 读取时无法解析的值；命令行参数覆盖该键后清除
+	errs     []error          // validate 的语义错误，每次 Validate 重新计算
+}
+
+func newLoader(file map[string]string, path string) *loader {
+	return &loader{file: file, filePath: path, settings: map[string]Setting{}, invalid: map[string]error{}}
+}
+
+// lookup 返回 k 的值及来源；环境变量显式设为空串也算设置了，可用来清掉配置文件里的值（回到默认）
+func (l *loader) lookup(k string) (string, string) {
+	if v, ok := os.LookupEnv(k); ok {
+		return v, "env"
+	}
+	if v := l.file[k]; v != "" {
+		return v, l.filePath
+	}
+	return "", ""
+}
+
+func (l *loader) record(k, v, origin string) {
+	if origin == "" {
+		origin = "default"
+	}
+	l.settings[k] = Setting{Key: k, Value: v, Origin: origin}
+}
+
+// fail 记录一个无法解析的值；原值照样记进 settings，config print 能看到是哪一项
+func (l *loader) fail(k, v, origin string, err error) {
+	l.record(k, v, origin)
+	l.invalid[k] = settingError(k, v, origin, err)
+}
+
+func settingError(k, v, origin string, err error) error {
+	return fmt.Errorf("%s=%q (%s): %v", k, v, origin, err)
+}
+
+func (l *loader) str(k, def string) string {
+	v, origin := l.lookup(k)
+	if v == "" {
+		v = def
+	}
+	l.record(k, v, origin)
+	return v
+}
+
+func (l *loader) boolean(k string, def bool) bool {
+	v, origin := l.lookup(k)
+	if v == "" {
+		l.record(k, strconv.FormatBool(def), origin)
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.fail(k, v, origin, fmt.Errorf("not a boolean"))
+		return def
+	}
+	l.record(k, v, origin)
+	return b
+}
+
+func (l *loader) integer(k string, def int) int {
+	v, origin := l.lookup(k)
+	if v == "" {
+		l.record(k, strconv.Itoa(def), origin)
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		l.fail(k, v, origin, fmt.Errorf("not an integer"))
+		return def
+	}
+	l.record(k, v, origin)
+	return i
+}
+
+// 逗号分隔的整数列表
+func (l *loader) integers(k string, def []int) []int {
+	v, origin := l.lookup(k)
+	if v == "" {
+		l.record(k, joinInts(def), origin)
+		return def
+	}
+	var out []int
+	for _, s := range splitList(v) {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			l.fail(k, v, origin, fmt.Errorf("%q is not an integer", s))
+			return def
+		}
+		out = append(out, i)
+	}
+	l.record(k, v, origin)
+	return out
+}
+
+func (l *loader) float(k string, def float64) float64 {
+	v, origin := l.lookup(k)
+	if v == "" {
+		l.record(k, strconv.FormatFloat(def, 'g', -1, 64), origin)
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		l.fail(k, v, origin, fmt.Errorf("not a number"))
+		return def
+	}
+	l.record(k, v, origin)
+	return f
+}
+
+func (l *loader) duration(k string, def time.Duration) time.Duration {
+	v, origin := l.lookup(k)
+	if v == "" {
+		l.record(k, def.String(), origin)
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		l.fail(k, v, origin, fmt.Errorf("not a duration (e.g. 30s, 10m)"))
+		return def
+	}
+	l.record(k, v, origin)
+	return d
+}
+
+// oneOf 取值必须在 allowed 中
+func (l *loader) oneOf(k, def string, allowed ...string) string {
+	v := l.str(k, def)
+	for _, a := range allowed {
+		if v == a {
+			return v
+		}
+	}
+	l.fail(k, v, l.settings[k].Origin, fmt.Errorf("want one of %s", strings.Join(allowed, ", ")))
+	return def
+}
+
+// check 记录一条语义校验错误
+func (l *loader) check(ok bool, k string, format string, args ...any) {
+	if !ok {
+		s := l.settings[k]
+		l.errs = append(l.errs, settingError(k, s.Value, s.Origin, fmt.Errorf(format, args...)))
+	}
+}
+
+func (l *loader) sortedSettings() []Setting {
+	out := make([]Setting, 0, len(l.settings))
+	for _, s := range l.settings {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+func joinInts(v []int) string {
+	s := make([]string, len(v))
+	for i, n := range v {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('[^']*'|\S+)`)
+
+// Redacted 返回适合打印的值：连接串 / 代理中的密码和按 host 配置的请求头（多为 token）被替换
+func (s Setting) Redacted() string {
+	switch {
+	case s.Value == "":
+		return ""
+	case s.Key == "HTTP_HOST_HEADERS":
+		var lines []string
+		for _, line := range strings.Split(s.Value, "\n") {
+			if host, kv, ok := strings.Cut(strings.TrimSpace(line), " "); ok {
+				name, _, _ := strings.Cut(kv, ":")
+				lines = append(lines, host+" "+strings.TrimSpace(name)+": <redacted>")
+			}
+		}
+		return strings.Join(lines, "\n")
+	case s.Key == "POSTGRES_CONNECTION_STRING" || s.Key == "HTTP_PROXY_URL":
+		if u, err := url.Parse(s.Value); err == nil && u.Scheme != "" {
+			return u.Redacted()
+		}
+		return dsnPassword.ReplaceAllString(s.Value, "${1}<redacted>")
+	}
+	return s.Value
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	const key = "PJSK_TEST_LOOKUP"
+	tests := []struct {
+		name       string
+		env        *string // nil 表示未设置
+		file       map[string]string
+		want       string
+		wantOrigin string
+	}{
+		{"unset, not in file", nil, nil, "", ""},
+		{"unset, from file", nil, map[string]string{key: "file"}, "file", "pjsk.yaml"},
+		{"env wins over file", ptr("env"), map[string]string{key: "file"}, "env", "env"},
+		{"empty env clears file", ptr(""), map[string]string{key: "file"}, "", "env"},
+		{"empty env, not in file", ptr(""), nil, "", "env"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(key, "") // 测试结束后恢复原状
+			if tt.env == nil {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, *tt.env)
+			}
+
+			l := newLoader(tt.file, "pjsk.yaml")
+			v, origin := l.lookup(key)
+			if v != tt.want || origin != tt.wantOrigin {
+				t.Fatalf("lookup = %q (%s), want %q (%s)", v, origin, tt.want, tt.wantOrigin)
+			}
+		})
+	}
+}
+
+// 显式设为空串的环境变量让配置文件里的值失效，回到默认值
+func TestEmptyEnvFallsBackToDefault(t *testing.T) {
+	t.Setenv("MAX_CONCURRENCY", "")
+	l := newLoader(map[string]string{"MAX_CONCURRENCY": "3"}, "pjsk.yaml")
+	if got := l.integer("MAX_CONCURRENCY", 6); got != 6 {
+		t.Fatalf("integer = %d, want default 6", got)
+	}
+	if s := l.settings["MAX_CONCURRENCY"]; s.Origin != "env" || s.Value != "6" {
+		t.Fatalf("setting = %+v, want value 6 from env", s)
+	}
+}
+
+func ptr(s string) *string { return &s }
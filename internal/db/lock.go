@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrLockHeld = errors.New("advisory lock is held by another session")
+
+// Lock 是一个会话级 advisory lock：必须在同一条连接上加锁 / 解锁，所以独占一条池连接
+type Lock struct {
+	conn *pgxpool.Conn
+	key  int64
+}
+
+// AcquireAdvisoryLock 获取 key 对应的锁。已被占用时记录持有者；
+// wait=false 直接返回 ErrLockHeld，否则阻塞等待（timeout>0 时最多等 timeout）。
+func AcquireAdvisoryLock(ctx context.Context, pool *pgxpool.Pool, key int64, wait bool, timeout time.Duration) (*Lock, error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&ok); err != nil {
+		conn.Release()
+		return nil, err
+	}
+	if ok {
+		return &Lock{conn: conn, key: key}, nil
+	}
+
+	holder := lockHolder(ctx, conn, key)
+	if !wait {
+		conn.Release()
+		return nil, fmt.Errorf("%w (%s)", ErrLockHeld, holder)
+	}
+	log.Printf("waiting for advisory lock %d held by %s", key, holder)
+
+	waitCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	if _, err := conn.Exec(waitCtx, `SELECT pg_advisory_lock($1)`, key); err != nil {
+		// 取消可能让连接处于未知状态，不放回池里
+		_ = conn.Conn().Close(context.WithoutCancel(ctx))
+		conn.Release()
+		return nil, fmt.Errorf("wait for advisory lock (%s): %w", holder, err)
+	}
+	log.Printf("advisory lock %d acquired after %s", key, time.Since(start).Round(time.Second))
+	return &Lock{conn: conn, key: key}, nil
+}
+
+func (l *Lock) Release(ctx context.Context) {
+	if _, err := l.conn.Exec(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, l.key); err != nil {
+		log.Printf("warn: advisory unlock %d failed: %v", l.key, err)
+	}
+	l.conn.Release()
+}
+
+// lockHolder 描述当前持有 key 的会话；bigint key 在 pg_locks 中拆为 classid(高 32 位) / objid(低 32 位)
+func lockHolder(ctx context.Context, conn *pgxpool.Conn, key int64) string {
+	var (
+		pid          int
+		app, client  string
+		backendStart time.Time
+	)
+	err := conn.QueryRow(ctx, `
+		SELECT a.pid, coalesce(a.application_name, ''), coalesce(host(a.client_addr), 'local'), a.backend_start
+		FROM pg_locks l
+		JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory' AND l.granted AND l.objsubid = 1
+		  AND l.classid::bigint = ($1::bigint >> 32) AND l.objid::bigint = ($1::bigint & 4294967295)
+		LIMIT 1
+	`, key).Scan(&pid, &app, &client, &backendStart)
+	if err != nil {
+		return fmt.Sprintf("unknown holder: %v", err)
+	}
+	return fmt.Sprintf("pid=%d application=%q client=%s since=%s", pid, app, client, backendStart.Format(time.RFC3339))
+}
@@ -0,0 +1,508 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"pjsk-sync/internal/config"
+)
+
+// migration 是一个版本化的 schema 变更；Up / Down 在同一事务内依次执行
+type migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// migrations 按版本号递增排列，已发布的条目不要再改，新变更追加新版本。
+//
+// 版本 1 是引入 schema_migrations 之前的完整 schema：全部 IF NOT EXISTS，
+// 且带旧库升级步骤，已有部署首次运行时会被原地收编。
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up:      append(legacyRegionUpgrade(), baselineSchema...),
+		Down: []string{
+			`DROP TABLE IF EXISTS pjsk_change_log;`,
+			`DROP TABLE IF EXISTS pjsk_sync_runs;`,
+			`DROP TABLE IF EXISTS pjsk_music_difficulties;`,
+			`DROP TABLE IF EXISTS pjsk_musics;`,
+			`DROP TABLE IF EXISTS pjsk_event_deck_bonuses;`,
+			`DROP TABLE IF EXISTS pjsk_event_cards;`,
+			`DROP TABLE IF EXISTS pjsk_events;`,
+			`DROP TABLE IF EXISTS pjsk_gacha_pickups;`,
+			`DROP TABLE IF EXISTS pjsk_gachas;`,
+			`DROP TABLE IF EXISTS pjsk_cards;`,
+			`DROP TABLE IF EXISTS pjsk_characters;`,
+			`DROP TABLE IF EXISTS pjsk_units;`,
+		},
+	},
+	{
+		// upstream 原始对象：尚未建模的字段（技能、发布时间、卡池行为……）可直接查询
+		Version: 2,
+		Name:    "raw_master_json",
+		Up: []string{
+			`ALTER TABLE pjsk_units ADD COLUMN IF NOT EXISTS raw JSONB;`,
+			`ALTER TABLE pjsk_characters ADD COLUMN IF NOT EXISTS raw JSONB;`,
+			`ALTER TABLE pjsk_cards ADD COLUMN IF NOT EXISTS raw JSONB;`,
+			`ALTER TABLE pjsk_gachas ADD COLUMN IF NOT EXISTS raw JSONB;`,
+			`ALTER TABLE pjsk_events ADD COLUMN IF NOT EXISTS raw JSONB;`,
+			`ALTER TABLE pjsk_event_cards ADD COLUMN IF NOT EXISTS raw JSONB;`,
+			`ALTER TABLE pjsk_event_deck_bonuses ADD COLUMN IF NOT EXISTS raw JSONB;`,
+			`ALTER TABLE pjsk_musics ADD COLUMN IF NOT EXISTS raw JSONB;`,
+			`ALTER TABLE pjsk_music_difficulties ADD COLUMN IF NOT EXISTS raw JSONB;`,
+		},
+		Down: []string{
+			`ALTER TABLE pjsk_units DROP COLUMN IF EXISTS raw;`,
+			`ALTER TABLE pjsk_characters DROP COLUMN IF EXISTS raw;`,
+			`ALTER TABLE pjsk_cards DROP COLUMN IF EXISTS raw;`,
+			`ALTER TABLE pjsk_gachas DROP COLUMN IF EXISTS raw;`,
+			`ALTER TABLE pjsk_events DROP COLUMN IF EXISTS raw;`,
+			`ALTER TABLE pjsk_event_cards DROP COLUMN IF EXISTS raw;`,
+			`ALTER TABLE pjsk_event_deck_bonuses DROP COLUMN IF EXISTS raw;`,
+			`ALTER TABLE pjsk_musics DROP COLUMN IF EXISTS raw;`,
+			`ALTER TABLE pjsk_music_difficulties DROP COLUMN IF EXISTS raw;`,
+		},
+	},
+	{
+		// master 文件的 ETag / Last-Modified，用于条件请求
+		Version: 3,
+		Name:    "fetch_cache",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS pjsk_fetch_cache (
+				url TEXT PRIMARY KEY,
+				etag TEXT NOT NULL DEFAULT '',
+				last_modified TEXT NOT NULL DEFAULT '',
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			);`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS pjsk_fetch_cache;`,
+		},
+	},
+	{
+		// 各服务器上次同步的 versions.json 版本
+		Version: 4,
+		Name:    "sync_state",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS pjsk_sync_state (
+				region TEXT PRIMARY KEY,
+				data_version TEXT NOT NULL DEFAULT '',
+				asset_version TEXT NOT NULL DEFAULT '',
+				data_synced_at TIMESTAMPTZ,
+				assets_synced_at TIMESTAMPTZ
+			);`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS pjsk_sync_state;`,
+		},
+	},
+	{
+		// 条件请求缓存按服务器区分：多个服务器可能指向同一个 URL（如 cn 的 cards 覆盖），
+		// 只按 URL 记录时一个服务器落库后，另一个会拿到 304 而漏写自己的行。
+		// 旧记录无法归属到服务器，直接清掉，代价只是下一次完整拉取。
+		Version: 5,
+		Name:    "fetch_cache_region",
+		Up: []string{
+			`DELETE FROM pjsk_fetch_cache;`,
+			`ALTER TABLE pjsk_fetch_cache ADD COLUMN region TEXT NOT NULL;`,
+			`ALTER TABLE pjsk_fetch_cache DROP CONSTRAINT pjsk_fetch_cache_pkey, ADD PRIMARY KEY (region, url);`,
+		},
+		Down: []string{
+			`DELETE FROM pjsk_fetch_cache;`,
+			`ALTER TABLE pjsk_fetch_cache DROP CONSTRAINT pjsk_fetch_cache_pkey, DROP COLUMN region, ADD PRIMARY KEY (url);`,
+		},
+	},
+}
+
+// 所有迁移共用的 advisory lock key，防止两个进程同时迁移
+const migrateLockKey = 0x706a736b_6d696772 // "pjskmigr"
+
+// MigrationState 是 migrate status 的一行
+type MigrationState struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time // nil 表示未执行
+}
+
+func ensureMigrationsTable(ctx context.Context, pool *pgxpool.Pool) error {
+	_, err := pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`)
+	return err
+}
+
+func appliedVersions(ctx context.Context, pool *pgxpool.Pool) (map[int]time.Time, error) {
+	rows, err := pool.Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var v int
+		var at time.Time
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		applied[v] = at
+	}
+	return applied, rows.Err()
+}
+
+// MigrateUp 依次执行所有未执行的迁移，每个版本一个事务
+func MigrateUp(ctx context.Context, pool *pgxpool.Pool) error {
+	if err := ensureMigrationsTable(ctx, pool); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	for _, m := range migrations {
+		applied, err := applyMigration(ctx, pool, m, true)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) up: %w", m.Version, m.Name, err)
+		}
+		if applied {
+			log.Printf("migration %d (%s) applied", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// MigrateDown 回滚最近执行的 steps 个版本
+func MigrateDown(ctx context.Context, pool *pgxpool.Pool, steps int) error {
+	if err := ensureMigrationsTable(ctx, pool); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		reverted, err := applyMigration(ctx, pool, m, false)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) down: %w", m.Version, m.Name, err)
+		}
+		if reverted {
+			log.Printf("migration %d (%s) reverted", m.Version, m.Name)
+			steps--
+		}
+	}
+	return nil
+}
+
+// applyMigration 在事务内执行单个版本；目标状态已满足时返回 false
+func applyMigration(ctx context.Context, pool *pgxpool.Pool, m migration, up bool) (bool, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// 拿锁后再判断是否已执行：另一个进程可能刚刚执行完同一版本
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, int64(migrateLockKey)); err != nil {
+		return false, err
+	}
+	var done bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version=$1)`, m.Version).Scan(&done); err != nil {
+		return false, err
+	}
+	if done == up {
+		return false, nil
+	}
+
+	stmts := m.Up
+	if !up {
+		stmts = m.Down
+	}
+	for _, s := range stmts {
+		if _, err := tx.Exec(ctx, s); err != nil {
+			return false, err
+		}
+	}
+
+	if up {
+		_, err = tx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+	} else {
+		_, err = tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version=$1`, m.Version)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// MigrationStatus 列出所有已知版本及其执行时间
+func MigrationStatus(ctx context.Context, pool *pgxpool.Pool) ([]MigrationState, error) {
+	if err := ensureMigrationsTable(ctx, pool); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	applied, err := appliedVersions(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		st := MigrationState{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			st.AppliedAt = &at
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+var baselineSchema = []string{
+	`CREATE TABLE IF NOT EXISTS pjsk_units (
+		region TEXT NOT NULL,
+		unit TEXT NOT NULL,
+		unit_name TEXT NOT NULL,
+		seq INT NOT NULL,
+		profile_sentence TEXT NOT NULL DEFAULT '',
+		color_code TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (region, unit)
+	);`,
+
+	`CREATE TABLE IF NOT EXISTS pjsk_characters (
+		region TEXT NOT NULL,
+		id INT NOT NULL,
+		seq INT NOT NULL,
+		resource_id INT NOT NULL,
+		first_name TEXT NOT NULL DEFAULT '',
+		given_name TEXT NOT NULL DEFAULT '',
+		first_name_ruby TEXT NOT NULL DEFAULT '',
+		given_name_ruby TEXT NOT NULL DEFAULT '',
+		gender TEXT NOT NULL DEFAULT '',
+		unit TEXT,
+		support_unit_type TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (region, id)
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_pjsk_characters_unit ON pjsk_characters(region, unit);`,
+
+	`CREATE TABLE IF NOT EXISTS pjsk_cards (
+		region TEXT NOT NULL,
+		id INT NOT NULL,
+		character_id INT NOT NULL,
+		attr TEXT NOT NULL,
+		prefix TEXT NOT NULL DEFAULT '',
+		rarity TEXT NOT NULL,
+		assetbundle_name TEXT NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		deleted_at TIMESTAMPTZ, -- upstream master 已撤下；NULL 表示仍在
+		PRIMARY KEY (region, id)
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_pjsk_cards_character_id ON pjsk_cards(character_id);`,
+	`CREATE INDEX IF NOT EXISTS idx_pjsk_cards_assetbundle_name ON pjsk_cards(assetbundle_name);`,
+
+	`CREATE TABLE IF NOT EXISTS pjsk_gachas (
+		region TEXT NOT NULL,
+		id INT NOT NULL,
+		gacha_type TEXT NOT NULL,
+		name TEXT NOT NULL,
+		seq INT NOT NULL,
+		assetbundle_name TEXT NOT NULL,
+		start_at BIGINT NOT NULL,
+		end_at BIGINT NOT NULL,
+		pool_category TEXT NOT NULL,
+		rarity4_rate REAL,
+		birthday_rate REAL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		deleted_at TIMESTAMPTZ,
+		PRIMARY KEY (region, id)
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_pjsk_gachas_start_end ON pjsk_gachas(start_at, end_at);`,
+
+	`CREATE TABLE IF NOT EXISTS pjsk_gacha_pickups (
+		region TEXT NOT NULL,
+		gacha_id INT NOT NULL,
+		card_id INT NOT NULL,
+		character_id INT,
+		PRIMARY KEY (region, gacha_id, card_id)
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_pjsk_gacha_pickups_gacha_id ON pjsk_gacha_pickups(gacha_id);`,
+	`CREATE INDEX IF NOT EXISTS idx_pjsk_gacha_pickups_character_id ON pjsk_gacha_pickups(character_id);`,
+
+	`CREATE TABLE IF NOT EXISTS pjsk_events (
+		region TEXT NOT NULL,
+		id INT NOT NULL,
+		event_type TEXT NOT NULL,
+		name TEXT NOT NULL,
+		assetbundle_name TEXT NOT NULL,
+		bgm_assetbundle_name TEXT NOT NULL DEFAULT '',
+		event_only_component_display_start_at BIGINT,
+		start_at BIGINT NOT NULL,
+		aggregate_at BIGINT,
+		ranking_announce_at BIGINT,
+		distribution_start_at BIGINT,
+		event_only_component_display_end_at BIGINT,
+		closed_at BIGINT,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		deleted_at TIMESTAMPTZ,
+		PRIMARY KEY (region, id)
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_pjsk_events_start_at ON pjsk_events(start_at);`,
+
+	`CREATE TABLE IF NOT EXISTS pjsk_event_cards (
+		region TEXT NOT NULL,
+		id INT NOT NULL,
+		event_id INT NOT NULL,
+		card_id INT NOT NULL,
+		bonus_rate REAL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (region, id)
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_pjsk_event_cards_event_id ON pjsk_event_cards(region, event_id);`,
+	`CREATE INDEX IF NOT EXISTS idx_pjsk_event_cards_card_id ON pjsk_event_cards(region, card_id);`,
+
+	`CREATE TABLE IF NOT EXISTS pjsk_event_deck_bonuses (
+		region TEXT NOT NULL,
+		id INT NOT NULL,
+		event_id INT NOT NULL,
+		game_character_unit_id INT,
+		card_attr TEXT,
+		bonus_rate REAL NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (region, id)
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_pjsk_event_deck_bonuses_event_id ON pjsk_event_deck_bonuses(region, event_id);`,
+
+	`CREATE TABLE IF NOT EXISTS pjsk_musics (
+		region TEXT NOT NULL,
+		id INT NOT NULL,
+		seq INT NOT NULL,
+		title TEXT NOT NULL,
+		pronunciation TEXT NOT NULL DEFAULT '',
+		categories TEXT[] NOT NULL DEFAULT '{}',
+		lyricist TEXT NOT NULL DEFAULT '',
+		composer TEXT NOT NULL DEFAULT '',
+		arranger TEXT NOT NULL DEFAULT '',
+		assetbundle_name TEXT NOT NULL,
+		filler_sec REAL,
+		published_at BIGINT,
+		released_at BIGINT,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		deleted_at TIMESTAMPTZ,
+		PRIMARY KEY (region, id)
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_pjsk_musics_published_at ON pjsk_musics(published_at);`,
+
+	`CREATE TABLE IF NOT EXISTS pjsk_music_difficulties (
+		region TEXT NOT NULL,
+		id INT NOT NULL,
+		music_id INT NOT NULL,
+		music_difficulty TEXT NOT NULL,
+		play_level INT NOT NULL,
+		total_note_count INT NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (region, id)
+	);`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_pjsk_music_difficulties_region_music ON pjsk_music_difficulties(region, music_id, music_difficulty);`,
+
+	`CREATE TABLE IF NOT EXISTS pjsk_sync_runs (
+		id BIGSERIAL PRIMARY KEY,
+		started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		finished_at TIMESTAMPTZ,
+		status TEXT NOT NULL, -- running / ok / failed
+		error TEXT NOT NULL DEFAULT ''
+	);`,
+
+	// 每次同步中内容真正发生变化的行；before/after 为整行 JSONB（不含 updated_at）
+	`CREATE TABLE IF NOT EXISTS pjsk_change_log (
+		id BIGSERIAL PRIMARY KEY,
+		run_id BIGINT NOT NULL REFERENCES pjsk_sync_runs(id) ON DELETE CASCADE,
+		region TEXT NOT NULL,
+		entity TEXT NOT NULL, -- 表名
+		entity_key TEXT NOT NULL, -- 不含 region 的主键
+		op TEXT NOT NULL, -- insert / update / delete
+		before JSONB,
+		after JSONB,
+		changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_pjsk_change_log_run_id ON pjsk_change_log(run_id);`,
+	`CREATE INDEX IF NOT EXISTS idx_pjsk_change_log_entity ON pjsk_change_log(entity, region, entity_key);`,
+
+	// deleted_at 晚于 region 引入：旧库补列
+	`ALTER TABLE pjsk_cards ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;`,
+	`ALTER TABLE pjsk_gachas ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;`,
+	`ALTER TABLE pjsk_events ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;`,
+	`ALTER TABLE pjsk_musics ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;`,
+
+	addConstraint("pjsk_characters", "fk_pjsk_characters_region_unit",
+		`FOREIGN KEY (region, unit) REFERENCES pjsk_units(region, unit)`),
+	addConstraint("pjsk_gacha_pickups", "fk_pjsk_gacha_pickups_region_gacha",
+		`FOREIGN KEY (region, gacha_id) REFERENCES pjsk_gachas(region, id) ON DELETE CASCADE`),
+	addConstraint("pjsk_gacha_pickups", "fk_pjsk_gacha_pickups_region_card",
+		`FOREIGN KEY (region, card_id) REFERENCES pjsk_cards(region, id)`),
+	addConstraint("pjsk_event_cards", "fk_pjsk_event_cards_region_event",
+		`FOREIGN KEY (region, event_id) REFERENCES pjsk_events(region, id) ON DELETE CASCADE`),
+	addConstraint("pjsk_event_cards", "fk_pjsk_event_cards_region_card",
+		`FOREIGN KEY (region, card_id) REFERENCES pjsk_cards(region, id)`),
+	addConstraint("pjsk_event_deck_bonuses", "fk_pjsk_event_deck_bonuses_region_event",
+		`FOREIGN KEY (region, event_id) REFERENCES pjsk_events(region, id) ON DELETE CASCADE`),
+	addConstraint("pjsk_music_difficulties", "fk_pjsk_music_difficulties_region_music",
+		`FOREIGN KEY (region, music_id) REFERENCES pjsk_musics(region, id) ON DELETE CASCADE`),
+	// 旧库的 character_id 列没有外键：NOT VALID 只约束新写入的行，避免迁移时被历史数据卡住
+	addConstraint("pjsk_cards", "fk_pjsk_cards_region_character",
+		`FOREIGN KEY (region, character_id) REFERENCES pjsk_characters(region, id) NOT VALID`),
+	addConstraint("pjsk_gacha_pickups", "fk_pjsk_gacha_pickups_region_character",
+		`FOREIGN KEY (region, character_id) REFERENCES pjsk_characters(region, id) NOT VALID`),
+}
+
+// ADD CONSTRAINT 没有 IF NOT EXISTS：按约束名查 pg_constraint 保证幂等
+func addConstraint(table, name, def string) string {
+	return fmt.Sprintf(`DO $$ BEGIN
+		IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = '%s') THEN
+			ALTER TABLE %s ADD CONSTRAINT %s %s;
+		END IF;
+	END $$;`, name, table, name, def)
+}
+
+// 引入 region 之前的表：主键只有 id，外键直接引用 id
+var legacyRegionTables = []struct {
+	table string
+	pk    string
+}{
+	{"pjsk_units", "unit"},
+	{"pjsk_characters", "id"},
+	{"pjsk_cards", "id"},
+	{"pjsk_gachas", "id"},
+	{"pjsk_gacha_pickups", "gacha_id, card_id"},
+	{"pjsk_events", "id"},
+	{"pjsk_musics", "id"},
+	{"pjsk_music_difficulties", "id"},
+}
+
+// 把旧的单服务器表升级为 (region, ...) 主键；存量数据都来自国服，region 记为 DefaultRegion。
+// 每张表以“是否已有 region 列”判断是否需要升级，重复执行是空操作。
+func legacyRegionUpgrade() []string {
+	stmts := []string{
+		// 旧外键依赖旧主键，必须先拆；新外键在 Migrate 末尾按新名字重建
+		`ALTER TABLE IF EXISTS pjsk_gacha_pickups DROP CONSTRAINT IF EXISTS pjsk_gacha_pickups_gacha_id_fkey;`,
+		`ALTER TABLE IF EXISTS pjsk_gacha_pickups DROP CONSTRAINT IF EXISTS pjsk_gacha_pickups_card_id_fkey;`,
+		`ALTER TABLE IF EXISTS pjsk_gacha_pickups DROP CONSTRAINT IF EXISTS fk_pjsk_gacha_pickups_character;`,
+		`ALTER TABLE IF EXISTS pjsk_cards DROP CONSTRAINT IF EXISTS fk_pjsk_cards_character;`,
+		`ALTER TABLE IF EXISTS pjsk_characters DROP CONSTRAINT IF EXISTS pjsk_characters_unit_fkey;`,
+		`ALTER TABLE IF EXISTS pjsk_music_difficulties DROP CONSTRAINT IF EXISTS pjsk_music_difficulties_music_id_fkey;`,
+		`DROP INDEX IF EXISTS idx_pjsk_music_difficulties_music;`,
+	}
+	for _, t := range legacyRegionTables {
+		stmts = append(stmts, fmt.Sprintf(`DO $$ BEGIN
+			IF to_regclass('%[1]s') IS NOT NULL AND NOT EXISTS (
+				SELECT 1 FROM information_schema.columns
+				WHERE table_schema = current_schema() AND table_name = '%[1]s' AND column_name = 'region'
+			) THEN
+				ALTER TABLE %[1]s ADD COLUMN region TEXT NOT NULL DEFAULT '%[2]s';
+				ALTER TABLE %[1]s ALTER COLUMN region DROP DEFAULT;
+				ALTER TABLE %[1]s DROP CONSTRAINT %[1]s_pkey;
+				ALTER TABLE %[1]s ADD PRIMARY KEY (region, %[3]s);
+			END IF;
+		END $$;`, t.table, config.DefaultRegion, t.pk))
+	}
+	return stmts
+}
@@ -0,0 +1,127 @@
+package httpx
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Options 描述所有出站请求共用的 transport
+type Options struct {
+	ProxyURL  string // 为空时沿用 HTTP_PROXY / HTTPS_PROXY / NO_PROXY
+	UserAgent string
+
+	// 按 host（不含端口）附加的请求头，例如镜像站的鉴权 token
+	HostHeaders map[string]http.Header
+
+	ConnectTimeout time.Duration // 建连（含 TLS 握手）
+	ReadTimeout    time.Duration // 发出请求后等待响应头
+	Timeout        time.Duration // 单次请求总时长（含读 body），不含重试间隔
+
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	HTTP2               bool
+
+	Retry RetryPolicy
+}
+
+func DefaultOptions() Options {
+	return Options{
+		UserAgent:           "pjsk-sync-action",
+		ConnectTimeout:      10 * time.Second,
+		ReadTimeout:         30 * time.Second,
+		Timeout:             60 * time.Second,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 16,
+		HTTP2:               true,
+		Retry:               DefaultRetryPolicy(),
+	}
+}
+
+// Client 是 sekai 与 assets 共用的 HTTP 客户端：同一个连接池，统一的头部和重试
+type Client struct {
+	http  *http.Client
+	retry RetryPolicy
+}
+
+func New(o Options) (*Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if o.ProxyURL != "" {
+		u, err := url.Parse(o.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	dialer := &net.Dialer{Timeout: o.ConnectTimeout, KeepAlive: 30 * time.Second}
+	tr := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   o.ConnectTimeout,
+		ResponseHeaderTimeout: o.ReadTimeout,
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          o.MaxIdleConns,
+		MaxIdleConnsPerHost:   o.MaxIdleConnsPerHost,
+		ForceAttemptHTTP2:     o.HTTP2,
+	}
+	if !o.HTTP2 {
+		// 非 nil 的空 map 才能关掉自动协商 h2
+		tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	hosts := make(map[string]http.Header, len(o.HostHeaders))
+	for h, hdr := range o.HostHeaders {
+		hosts[strings.ToLower(h)] = hdr
+	}
+
+	return &Client{
+		http: &http.Client{
+			Timeout:   o.Timeout,
+			Transport: &headerTransport{base: tr, userAgent: o.UserAgent, hosts: hosts},
+		},
+		retry: o.Retry,
+	}, nil
+}
+
+// Get 发起带重试的 GET；header 为本次请求额外的头（如条件请求）。调用方负责关闭 body。
+func (c *Client) Get(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	return c.retry.Do(ctx, c.http, req)
+}
+
+// headerTransport 按目标 host 补上配置的请求头。重定向到其他 host 时按新 host 匹配，token 不会被带过去
+type headerTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	hosts     map[string]http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	extra := t.hosts[strings.ToLower(req.URL.Hostname())]
+	if len(extra) == 0 && (t.userAgent == "" || req.Header.Get("User-Agent") != "") {
+		return t.base.RoundTrip(req)
+	}
+
+	// RoundTripper 不能改调用方的请求
+	r := req.Clone(req.Context())
+	if t.userAgent != "" && r.Header.Get("User-Agent") == "" {
+		r.Header.Set("User-Agent", t.userAgent)
+	}
+	for k, vs := range extra {
+		r.Header[http.CanonicalHeaderKey(k)] = vs
+	}
+	return t.base.RoundTrip(r)
+}
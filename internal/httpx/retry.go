@@ -0,0 +1,140 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy 描述失败请求的重试方式：网络错误和 RetryOn 中的状态码会按指数退避（带抖动）重试
+type RetryPolicy struct {
+	MaxAttempts int           // 含首次请求；<=1 表示不重试
+	BaseDelay   time.Duration // 第 n 次重试前等待 BaseDelay*2^(n-1)，再乘以 [0.5,1.5) 的抖动
+	MaxDelay    time.Duration // 单次等待上限，Retry-After 也受其约束
+	RetryOn     []int
+}
+
+// DefaultRetryStatuses 是常见的临时性错误：超时、限流和网关 / 上游故障
+var DefaultRetryStatuses = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooEarly,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    30 * time.Second,
+		RetryOn:     DefaultRetryStatuses,
+	}
+}
+
+func (p RetryPolicy) retryable(status int) bool {
+	for _, s := range p.RetryOn {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Do 发送 req（必须可重放，即无 body 或带 GetBody），按策略重试。
+// 重试耗尽时返回最后一次的响应（调用方照常检查状态码）或错误。
+func (p RetryPolicy) Do(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	attempts := max(p.MaxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		r := req.Clone(ctx)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = body
+		}
+
+		resp, err := client.Do(r)
+		if err == nil && !p.retryable(resp.StatusCode) {
+			return resp, nil
+		}
+		if err != nil && ctx.Err() != nil {
+			return nil, err
+		}
+		if attempt >= attempts {
+			return resp, err
+		}
+
+		wait := p.backoff(attempt)
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = "status=" + strconv.Itoa(resp.StatusCode)
+			if ra, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				// 与 backoff 一致：MaxDelay 为 0 表示不设上限
+				wait = ra
+				if p.MaxDelay > 0 {
+					wait = min(ra, p.MaxDelay)
+				}
+			}
+			// 读完再关，连接才能复用
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		log.Printf("http: %s %s attempt %d/%d failed (%s), retry in %s",
+			req.Method, req.URL.Redacted(), attempt, attempts, reason, wait.Round(time.Millisecond))
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, errors.Join(ctx.Err(), err)
+		case <-t.C:
+		}
+	}
+}
+
+// backoff 返回第 attempt 次失败后的等待时间；超过上限（MaxDelay，为 0 时是 time.Duration 的最大值）
+// 时取上限，重试次数很大时也不会溢出成负数而立即重试
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	limit := p.MaxDelay
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+	d := max(p.BaseDelay, 0)
+	if shift := attempt - 1; shift > 0 && d > 0 {
+		if shift >= 63 || d > limit>>shift {
+			return limit
+		}
+		d <<= shift
+	}
+	f := float64(d) * (0.5 + rand.Float64())
+	if f >= float64(limit) {
+		return limit
+	}
+	return time.Duration(f)
+}
+
+// Retry-After 可以是秒数，也可以是 HTTP 日期
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
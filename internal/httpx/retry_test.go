@@ -0,0 +1,62 @@
+package httpx
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	const ms = time.Millisecond
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		attempt  int
+		min, max time.Duration // 结果落在 [min, max]
+	}{
+		{"first retry", RetryPolicy{BaseDelay: 100 * ms}, 1, 50 * ms, 150 * ms},
+		{"doubles per attempt", RetryPolicy{BaseDelay: 100 * ms}, 3, 200 * ms, 600 * ms},
+		{"capped by MaxDelay", RetryPolicy{BaseDelay: time.Second, MaxDelay: 2 * time.Second}, 5, 2 * time.Second, 2 * time.Second},
+		{"zero base delay", RetryPolicy{MaxDelay: time.Second}, 3, 0, 0},
+		{"shift overflow with MaxDelay", RetryPolicy{BaseDelay: 500 * ms, MaxDelay: 30 * time.Second}, 100, 30 * time.Second, 30 * time.Second},
+		{"shift overflow without MaxDelay", RetryPolicy{BaseDelay: 500 * ms}, 100, math.MaxInt64, math.MaxInt64},
+		{"multiply overflow without MaxDelay", RetryPolicy{BaseDelay: 500 * ms}, 41, math.MaxInt64, math.MaxInt64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 抖动是随机的，多跑几次
+			for range 100 {
+				got := tt.policy.backoff(tt.attempt)
+				if got < tt.min || got > tt.max {
+					t.Fatalf("backoff(%d) = %s, want within [%s, %s]", tt.attempt, got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		min, max time.Duration
+		ok       bool
+	}{
+		{"missing", "", 0, 0, false},
+		{"seconds", "5", 5 * time.Second, 5 * time.Second, true},
+		{"zero seconds", "0", 0, 0, true},
+		{"negative seconds", "-1", 0, 0, false},
+		{"garbage", "soon", 0, 0, false},
+		{"date in the past", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0, true},
+		{"date in the future", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), 58 * time.Minute, time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := retryAfter(tt.header)
+			if ok != tt.ok || got < tt.min || got > tt.max {
+				t.Fatalf("retryAfter(%q) = %s, %t; want [%s, %s], %t", tt.header, got, ok, tt.min, tt.max, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package sekai
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalPath 判断 master 地址是否指向本地文件：file:// URL 或不带 scheme 的路径。
+// 用于离线重建，或在 CI 里直接读 checkout 下来的 master 仓库。
+func LocalPath(raw string) (string, bool) {
+	if strings.HasPrefix(raw, "file://") {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return "", false
+		}
+		// file://relative/path 会把第一段解析成 host，拼回去当相对路径
+		return filepath.FromSlash(u.Host + u.Path), true
+	}
+	if raw == "" || strings.Contains(raw, "://") {
+		return "", false
+	}
+	return raw, true
+}
+
+// openLocal 用文件大小和 mtime 充当校验头，文件没动过时同样返回 ErrNotModified
+func openLocal(path string, prev Validators) (io.ReadCloser, Validators, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, Validators{}, fmt.Errorf("open master: %w", err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Validators{}, err
+	}
+	if st.IsDir() {
+		f.Close()
+		return nil, Validators{}, fmt.Errorf("open master: %s is a directory", path)
+	}
+
+	v := Validators{
+		ETag:         fmt.Sprintf(`"%x-%x"`, st.Size(), st.ModTime().UnixNano()),
+		LastModified: st.ModTime().UTC().Format(http.TimeFormat),
+	}
+	if prev.ETag == v.ETag {
+		f.Close()
+		return nil, prev, ErrNotModified
+	}
+	return f, v, nil
+}
@@ -3,10 +3,12 @@ package sekai
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"time"
+
+	"pjsk-sync/internal/httpx"
 )
 
 type Gacha struct {
@@ -28,6 +30,13 @@ type Gacha struct {
 		GachaID int `json:"gachaId"`
 		CardID  int `json:"cardId"`
 	} `json:"gachaPickups"`
+
+	Raw json.RawMessage `json:"-"` // upstream 原始对象，原样存进 raw 列
+}
+
+func (v *Gacha) UnmarshalJSON(b []byte) error {
+	type plain Gacha
+	return decodeWithRaw(b, (*plain)(v), &v.Raw)
 }
 
 type Card struct {
@@ -37,6 +46,14 @@ type Card struct {
 	Attr            string `json:"attr"`
 	Prefix          string `json:"prefix"`
 	AssetbundleName string `json:"assetbundleName"`
+	ReleaseAt       int64  `json:"releaseAt"` // ms
+
+	Raw json.RawMessage `json:"-"`
+}
+
+func (v *Card) UnmarshalJSON(b []byte) error {
+	type plain Card
+	return decodeWithRaw(b, (*plain)(v), &v.Raw)
 }
 
 type Event struct {
@@ -52,38 +69,196 @@ type Event struct {
 	DistributionStartAt            int64  `json:"distributionStartAt"`
 	EventOnlyComponentDisplayEnd   int64  `json:"eventOnlyComponentDisplayEndAt"`
 	ClosedAt                       int64  `json:"closedAt"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+func (v *Event) UnmarshalJSON(b []byte) error {
+	type plain Event
+	return decodeWithRaw(b, (*plain)(v), &v.Raw)
+}
+
+type EventCard struct {
+	ID        int     `json:"id"`
+	CardID    int     `json:"cardId"`
+	EventID   int     `json:"eventId"`
+	BonusRate float32 `json:"bonusRate"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+func (v *EventCard) UnmarshalJSON(b []byte) error {
+	type plain EventCard
+	return decodeWithRaw(b, (*plain)(v), &v.Raw)
+}
+
+type EventDeckBonus struct {
+	ID                  int     `json:"id"`
+	EventID             int     `json:"eventId"`
+	GameCharacterUnitID int     `json:"gameCharacterUnitId"` // 0 表示不限角色
+	CardAttr            string  `json:"cardAttr"`            // 空表示不限属性
+	BonusRate           float32 `json:"bonusRate"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+func (v *EventDeckBonus) UnmarshalJSON(b []byte) error {
+	type plain EventDeckBonus
+	return decodeWithRaw(b, (*plain)(v), &v.Raw)
 }
 
-func FetchJSON[T any](ctx context.Context, url string) (T, error) {
+type Music struct {
+	ID              int      `json:"id"`
+	Seq             int      `json:"seq"`
+	Categories      []string `json:"categories"`
+	Title           string   `json:"title"`
+	Pronunciation   string   `json:"pronunciation"`
+	Lyricist        string   `json:"lyricist"`
+	Composer        string   `json:"composer"`
+	Arranger        string   `json:"arranger"`
+	AssetbundleName string   `json:"assetbundleName"`
+	PublishedAt     int64    `json:"publishedAt"` // ms
+	ReleasedAt      int64    `json:"releasedAt"`  // ms
+	FillerSec       float32  `json:"fillerSec"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+func (v *Music) UnmarshalJSON(b []byte) error {
+	type plain Music
+	return decodeWithRaw(b, (*plain)(v), &v.Raw)
+}
+
+type MusicDifficulty struct {
+	ID              int    `json:"id"`
+	MusicID         int    `json:"musicId"`
+	MusicDifficulty string `json:"musicDifficulty"` // easy / normal / hard / expert / master / append
+	PlayLevel       int    `json:"playLevel"`
+	TotalNoteCount  int    `json:"totalNoteCount"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+func (v *MusicDifficulty) UnmarshalJSON(b []byte) error {
+	type plain MusicDifficulty
+	return decodeWithRaw(b, (*plain)(v), &v.Raw)
+}
+
+type GameCharacter struct {
+	ID              int    `json:"id"`
+	Seq             int    `json:"seq"`
+	ResourceID      int    `json:"resourceId"`
+	FirstName       string `json:"firstName"`
+	GivenName       string `json:"givenName"`
+	FirstNameRuby   string `json:"firstNameRuby"`
+	GivenNameRuby   string `json:"givenNameRuby"`
+	Gender          string `json:"gender"`
+	Unit            string `json:"unit"`            // light_sound / idol / street / theme_park / school_refusal / piapro
+	SupportUnitType string `json:"supportUnitType"` // 虚拟歌手才有意义：none / unit / full
+
+	Raw json.RawMessage `json:"-"`
+}
+
+func (v *GameCharacter) UnmarshalJSON(b []byte) error {
+	type plain GameCharacter
+	return decodeWithRaw(b, (*plain)(v), &v.Raw)
+}
+
+type UnitProfile struct {
+	Unit            string `json:"unit"`
+	UnitName        string `json:"unitName"`
+	Seq             int    `json:"seq"`
+	ProfileSentence string `json:"profileSentence"`
+	ColorCode       string `json:"colorCode"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+func (v *UnitProfile) UnmarshalJSON(b []byte) error {
+	type plain UnitProfile
+	return decodeWithRaw(b, (*plain)(v), &v.Raw)
+}
+
+// Versions 是 master 仓库的 versions.json（只取用得到的字段）
+type Versions struct {
+	AppVersion   string `json:"appVersion"`
+	DataVersion  string `json:"dataVersion"`
+	AssetVersion string `json:"assetVersion"`
+}
+
+// decodeWithRaw 按字段解码的同时保留原始字节；b 可能是复用的缓冲区，必须拷贝
+func decodeWithRaw(b []byte, v any, raw *json.RawMessage) error {
+	if err := json.Unmarshal(b, v); err != nil {
+		return err
+	}
+	*raw = append(json.RawMessage(nil), b...)
+	return nil
+}
+
+// Validators 是上次成功拉取时服务端给的缓存校验头
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// ErrNotModified 表示条件请求命中 304：upstream 自上次拉取后没有变化
+var ErrNotModified = errors.New("not modified")
+
+func FetchJSON[T any](ctx context.Context, c *httpx.Client, url string) (T, error) {
+	out, _, err := FetchJSONConditional[T](ctx, c, url, Validators{})
+	return out, err
+}
+
+// FetchJSONConditional 带上 If-None-Match / If-Modified-Since 拉取；304 时返回 ErrNotModified。
+// 成功时返回本次响应的校验头，调用方在数据落库后再持久化它们。
+// url 也可以是 file:// 或本地路径，见 openLocal。
+func FetchJSONConditional[T any](ctx context.Context, c *httpx.Client, url string, prev Validators) (T, Validators, error) {
 	var zero T
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	rc, v, err := openMaster(ctx, c, url, prev)
 	if err != nil {
-		return zero, err
+		return zero, v, err
 	}
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("User-Agent", "pjsk-sync-action")
+	defer rc.Close()
 
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return zero, err
+	// 直接从 body 解码，不再额外持有一份完整的响应字节
+	var out T
+	if err := json.NewDecoder(rc).Decode(&out); err != nil {
+		return zero, Validators{}, err
 	}
-	defer resp.Body.Close()
+	return out, v, nil
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		b, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
-		return zero, fmt.Errorf("fetch %s: status=%d body=%s", url, resp.StatusCode, string(b))
+func openMaster(ctx context.Context, c *httpx.Client, url string, prev Validators) (io.ReadCloser, Validators, error) {
+	if path, ok := LocalPath(url); ok {
+		return openLocal(path, prev)
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	h := http.Header{}
+	h.Set("Accept", "application/json")
+	if prev.ETag != "" {
+		h.Set("If-None-Match", prev.ETag)
+	}
+	if prev.LastModified != "" {
+		h.Set("If-Modified-Since", prev.LastModified)
+	}
+
+	resp, err := c.Get(ctx, url, h)
 	if err != nil {
-		return zero, err
+		return nil, Validators{}, err
 	}
 
-	var out T
-	if err := json.Unmarshal(body, &out); err != nil {
-		return zero, err
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return nil, prev, ErrNotModified
 	}
-	return out, nil
-}
\ No newline at end of file
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+		resp.Body.Close()
+		return nil, Validators{}, fmt.Errorf("fetch %s: status=%d body=%s", url, resp.StatusCode, string(b))
+	}
+	return resp.Body, Validators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
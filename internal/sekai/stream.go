@@ -0,0 +1,117 @@
+package sekai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+
+	"pjsk-sync/internal/httpx"
+)
+
+// Stream 逐个解码 master 文件（顶层为数组）的元素：任一时刻只缓冲一个元素，
+// 不会像 FetchJSON 那样先读完整个响应再整体反序列化。
+type Stream[T any] struct {
+	Validators Validators // 本次响应的校验头
+
+	url string
+	rc  io.ReadCloser
+	dec *json.Decoder
+}
+
+// OpenStream 打开 url（HTTP 或本地文件）；条件请求命中时返回 ErrNotModified。调用方负责 Close。
+func OpenStream[T any](ctx context.Context, c *httpx.Client, url string, prev Validators) (*Stream[T], error) {
+	rc, v, err := openMaster(ctx, c, url, prev)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream[T]{Validators: v, url: url, rc: rc, dec: json.NewDecoder(rc)}, nil
+}
+
+// OpenFile 打开本地 master 文件，通常是 Spool 落下的临时文件
+func OpenFile[T any](path string) (*Stream[T], error) {
+	rc, v, err := openLocal(path, Validators{})
+	if err != nil {
+		return nil, err
+	}
+	return &Stream[T]{Validators: v, url: path, rc: rc, dec: json.NewDecoder(rc)}, nil
+}
+
+// Spool 把 master 文件原样下载到 dir 下的临时文件并返回其路径，之后可以用 OpenFile 多次流式读取；
+// 本地 master 不复制，直接返回原路径。条件请求命中时返回 ErrNotModified。
+func Spool(ctx context.Context, c *httpx.Client, url string, prev Validators, dir string) (string, Validators, error) {
+	rc, v, err := openMaster(ctx, c, url, prev)
+	if err != nil {
+		return "", v, err
+	}
+	defer rc.Close()
+	if path, ok := LocalPath(url); ok {
+		return path, v, nil
+	}
+
+	f, err := os.CreateTemp(dir, "master-*.json")
+	if err != nil {
+		return "", Validators{}, err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", Validators{}, fmt.Errorf("download %s: %w", url, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", Validators{}, err
+	}
+	return f.Name(), v, nil
+}
+
+// All 按顺序产出数组元素；遇到错误时产出一次 (零值, err) 后结束。只能遍历一次。
+func (s *Stream[T]) All() iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		if err := s.expect('['); err != nil {
+			yield(zero, err)
+			return
+		}
+		for s.dec.More() {
+			var v T
+			if err := s.dec.Decode(&v); err != nil {
+				yield(zero, fmt.Errorf("decode %s: %w", s.url, err))
+				return
+			}
+			if !yield(v, nil) {
+				return
+			}
+		}
+		if err := s.expect(']'); err != nil {
+			yield(zero, err)
+		}
+	}
+}
+
+func (s *Stream[T]) Close() error { return s.rc.Close() }
+
+func (s *Stream[T]) expect(d json.Delim) error {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return fmt.Errorf("decode %s: %w", s.url, err)
+	}
+	if tok != d {
+		return fmt.Errorf("decode %s: want %q, got %v", s.url, d, tok)
+	}
+	return nil
+}
+
+// Collect 把整个流读进切片
+func Collect[T any](s *Stream[T]) ([]T, error) {
+	var out []T
+	for v, err := range s.All() {
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
@@ -0,0 +1,176 @@
+package sekai
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Invalid 是一条未通过校验、被隔离（不落库、不生成素材任务）的记录
+type Invalid struct {
+	Entity string `json:"entity"`
+	Index  int    `json:"index"` // 在 master 文件数组中的下标
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// Record 是可校验的 master 记录：Key 用于查重，Check 返回不合法的原因（合法时为空）
+type Record interface {
+	Key() string
+	Check() string
+}
+
+// Validate 剔除不合法的记录。重复 key 时保留最后一条（与逐行 upsert 的覆盖顺序一致），之前的记为重复。
+func Validate[T Record](entity string, items []T) ([]T, []Invalid) {
+	s := NewScreen[T](entity)
+	for _, it := range items {
+		s.Add(it)
+	}
+	out := items[:0:0]
+	for i, it := range items {
+		if !s.Skip(i) {
+			out = append(out, it)
+		}
+	}
+	return out, s.Invalid()
+}
+
+// Screen 按 Validate 的规则逐条校验，只记下标和 key，不持有记录本身：
+// 大文件边读边 Add，读完后用 Skip 判断某个下标是否要剔除。
+type Screen[T Record] struct {
+	entity string
+	n      int
+	last   map[string]int  // key -> 最后一次出现的下标
+	bad    map[int]Invalid // 被剔除的下标；Reason 为空表示被后面的同 key 记录覆盖
+}
+
+func NewScreen[T Record](entity string) *Screen[T] {
+	return &Screen[T]{entity: entity, last: map[string]int{}, bad: map[int]Invalid{}}
+}
+
+// Add 校验下一条记录，返回它的下标
+func (s *Screen[T]) Add(v T) int {
+	i, key := s.n, v.Key()
+	s.n++
+	if prev, ok := s.last[key]; ok {
+		if _, already := s.bad[prev]; !already {
+			s.bad[prev] = Invalid{Entity: s.entity, Index: prev, Key: key}
+		}
+	}
+	s.last[key] = i
+	if reason := v.Check(); reason != "" {
+		s.bad[i] = Invalid{Entity: s.entity, Index: i, Key: key, Reason: reason}
+	}
+	return i
+}
+
+// Skip 报告下标 i 的记录是否被剔除；只有全部 Add 完之后才是最终结果
+func (s *Screen[T]) Skip(i int) bool {
+	_, ok := s.bad[i]
+	return ok
+}
+
+// Index 返回 key 最后一次出现的下标，也就是去重后保留的那条
+func (s *Screen[T]) Index(key string) int { return s.last[key] }
+
+// Len 是已经 Add 的记录数
+func (s *Screen[T]) Len() int { return s.n }
+
+// Invalid 按下标顺序列出被剔除的记录
+func (s *Screen[T]) Invalid() []Invalid {
+	var out []Invalid
+	for i := range s.n {
+		b, ok := s.bad[i]
+		if !ok {
+			continue
+		}
+		if b.Reason == "" {
+			b.Reason = "duplicate key, superseded by index " + strconv.Itoa(s.last[b.Key])
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// checks 收集所有失败的规则，用 "; " 连接
+type checks []string
+
+func (c *checks) need(ok bool, msg string) {
+	if !ok {
+		*c = append(*c, msg)
+	}
+}
+
+func (c checks) String() string { return strings.Join(c, "; ") }
+
+func (v Gacha) Key() string { return strconv.Itoa(v.ID) }
+func (v Gacha) Check() string {
+	var c checks
+	c.need(v.ID > 0, "id must be positive")
+	c.need(v.AssetbundleName != "", "assetbundleName is empty")
+	return c.String()
+}
+
+func (v Card) Key() string { return strconv.Itoa(v.ID) }
+func (v Card) Check() string {
+	var c checks
+	c.need(v.ID > 0, "id must be positive")
+	c.need(v.CharacterID > 0, "characterId must be positive")
+	c.need(v.AssetbundleName != "", "assetbundleName is empty")
+	return c.String()
+}
+
+func (v Event) Key() string { return strconv.Itoa(v.ID) }
+func (v Event) Check() string {
+	var c checks
+	c.need(v.ID > 0, "id must be positive")
+	c.need(v.AssetbundleName != "", "assetbundleName is empty")
+	return c.String()
+}
+
+func (v EventCard) Key() string { return strconv.Itoa(v.ID) }
+func (v EventCard) Check() string {
+	var c checks
+	c.need(v.ID > 0, "id must be positive")
+	c.need(v.EventID > 0, "eventId must be positive")
+	c.need(v.CardID > 0, "cardId must be positive")
+	return c.String()
+}
+
+func (v EventDeckBonus) Key() string { return strconv.Itoa(v.ID) }
+func (v EventDeckBonus) Check() string {
+	var c checks
+	c.need(v.ID > 0, "id must be positive")
+	c.need(v.EventID > 0, "eventId must be positive")
+	return c.String()
+}
+
+func (v Music) Key() string { return strconv.Itoa(v.ID) }
+func (v Music) Check() string {
+	var c checks
+	c.need(v.ID > 0, "id must be positive")
+	c.need(v.Title != "", "title is empty")
+	return c.String()
+}
+
+func (v MusicDifficulty) Key() string { return strconv.Itoa(v.ID) }
+func (v MusicDifficulty) Check() string {
+	var c checks
+	c.need(v.ID > 0, "id must be positive")
+	c.need(v.MusicID > 0, "musicId must be positive")
+	c.need(v.MusicDifficulty != "", "musicDifficulty is empty")
+	return c.String()
+}
+
+func (v GameCharacter) Key() string { return strconv.Itoa(v.ID) }
+func (v GameCharacter) Check() string {
+	var c checks
+	c.need(v.ID > 0, "id must be positive")
+	return c.String()
+}
+
+func (v UnitProfile) Key() string { return v.Unit }
+func (v UnitProfile) Check() string {
+	var c checks
+	c.need(v.Unit != "", "unit is empty")
+	return c.String()
+}
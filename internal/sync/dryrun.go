@@ -0,0 +1,130 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// 演练输出里每类改动最多列出这么多条
+const maxDryRunItems = 10
+
+// planStaged 是 mergeStaged 的演练版本：把已 COPY 进临时表的行与正式表对比，
+// 按 mergeStaged 的判定统计将会新增 / 更新的行，并列出部分 key
+func planStaged(ctx context.Context, tx pgx.Tx, e stagedEntity, staged int64) (upsertCounts, error) {
+	var oldVals, newVals []string
+	for _, c := range e.columns[2:] {
+		oldVals = append(oldVals, "t."+c)
+		newVals = append(newVals, "s."+c)
+	}
+	if e.tombstone {
+		oldVals = append(oldVals, "t.deleted_at")
+		newVals = append(newVals, "NULL::timestamptz")
+	}
+	changed := fmt.Sprintf("t.region IS NOT NULL AND (%s) IS DISTINCT FROM (%s)", strings.Join(oldVals, ", "), strings.Join(newVals, ", "))
+
+	rows, err := tx.Query(ctx, fmt.Sprintf(`
+		SELECT s.region,
+		       count(*) FILTER (WHERE t.region IS NULL),
+		       count(*) FILTER (WHERE %[4]s),
+		       (array_agg(s.%[2]s::text ORDER BY s.%[2]s) FILTER (WHERE t.region IS NULL))[1:$1::int],
+		       (array_agg(s.%[2]s::text ORDER BY s.%[2]s) FILTER (WHERE %[4]s))[1:$1::int]
+		FROM %[3]s s LEFT JOIN %[1]s t ON t.region = s.region AND t.%[2]s = s.%[2]s
+		GROUP BY s.region ORDER BY s.region
+	`, e.table, e.key, stageTable(e.table), changed), maxDryRunItems)
+	if err != nil {
+		return upsertCounts{}, fmt.Errorf("plan %s: %w", e.table, err)
+	}
+	defer rows.Close()
+
+	var counts upsertCounts
+	for rows.Next() {
+		var region string
+		var inserted, updated int
+		var insertKeys, updateKeys []string
+		if err := rows.Scan(&region, &inserted, &updated, &insertKeys, &updateKeys); err != nil {
+			return counts, fmt.Errorf("plan %s: %w", e.table, err)
+		}
+		logPlanned(region, e.table, "insert", int64(inserted), insertKeys)
+		logPlanned(region, e.table, "update", int64(updated), updateKeys)
+		counts.Inserted += inserted
+		counts.Updated += updated
+	}
+	if err := rows.Err(); err != nil {
+		return counts, fmt.Errorf("plan %s: %w", e.table, err)
+	}
+	counts.Unchanged = int(staged) - counts.Inserted - counts.Updated
+	return counts, nil
+}
+
+// planRemoved 是 removeRows 的演练版本：统计将被标记墓碑 / 删除的行
+func planRemoved(ctx context.Context, tx pgx.Tx, region, table, key, cond string, params []any) (int64, error) {
+	args := append([]any{region}, params...)
+	var n int64
+	var keys []string
+	if err := tx.QueryRow(ctx, fmt.Sprintf(`
+		SELECT count(*), (array_agg(%[2]s ORDER BY %[2]s))[1:$%[4]d::int]
+		FROM %[1]s WHERE region=$1 AND (%[3]s)
+	`, table, key, cond, len(args)+1), append(args, maxDryRunItems)...).Scan(&n, &keys); err != nil {
+		return 0, err
+	}
+	logPlanned(region, table, "delete", n, keys)
+	return n, nil
+}
+
+func logPlanned(region, table, op string, n int64, keys []string) {
+	if n == 0 {
+		return
+	}
+	more := ""
+	if n > int64(len(keys)) {
+		more = ", ..."
+	}
+	log.Printf("dry-run [%s] %s %s=%d: %s%s", region, table, op, n, strings.Join(keys, ", "), more)
+}
+
+// logPlannedTotal 汇总各服务器报告里将会发生的改动
+func logPlannedTotal(masters []regionMaster) {
+	var total int64
+	for _, m := range masters {
+		if m.skipDB {
+			continue
+		}
+		for _, c := range m.report.Entities {
+			total += int64(c.Inserted + c.Updated)
+		}
+		total += m.report.Removed
+	}
+	log.Printf("dry-run: %d row changes planned, nothing written", total)
+}
+
+// logPlannedAssets 列出素材阶段会做的事，不访问网络、不写 IMAGE_REPO_DIR
+// 计数同时记进 rr：existing / migrated / saved 表示将会发生的处理
+func logPlannedAssets(region, root string, jobs []assetJob, rr *RegionReport) {
+	var download, migrate, skip int
+	for _, j := range jobs {
+		destAbs := filepath.Join(root, j.destRel)
+		switch {
+		case fileExists(destAbs):
+			rr.asset(j.kind, assetExisting)
+			skip++
+		case fileExists(strings.TrimSuffix(destAbs, ".webp") + ".png"):
+			rr.asset(j.kind, assetMigrated)
+			if migrate < maxDryRunItems {
+				log.Printf("dry-run [%s] would migrate png to webp: %s", region, j.destRel)
+			}
+			migrate++
+		default:
+			rr.asset(j.kind, assetSaved)
+			if download < maxDryRunItems {
+				log.Printf("dry-run [%s] would download: %s <- %s", region, j.destRel, j.urls[0])
+			}
+			download++
+		}
+	}
+	log.Printf("dry-run assets [%s]: download=%d migrate=%d skipped(existing)=%d total=%d", region, download, migrate, skip, len(jobs))
+}
@@ -0,0 +1,223 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"pjsk-sync/internal/httpx"
+	"pjsk-sync/internal/sekai"
+)
+
+// fetchCache 是某个服务器上次成功落库时各 master URL 的校验头。
+// 按服务器分开：同一个 URL 可能被多个服务器使用，各自的行要各自写入。
+type fetchCache map[string]sekai.Validators
+
+// loadFetchCache 返回按服务器分组的校验头
+func loadFetchCache(ctx context.Context, pool *pgxpool.Pool) (map[string]fetchCache, error) {
+	rows, err := pool.Query(ctx, `SELECT region, url, etag, last_modified FROM pjsk_fetch_cache`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	caches := map[string]fetchCache{}
+	for rows.Next() {
+		var region, url string
+		var v sekai.Validators
+		if err := rows.Scan(&region, &url, &v.ETag, &v.LastModified); err != nil {
+			return nil, err
+		}
+		if caches[region] == nil {
+			caches[region] = fetchCache{}
+		}
+		caches[region][url] = v
+	}
+	return caches, rows.Err()
+}
+
+func saveFetchCache(ctx context.Context, tx pgx.Tx, region string, validators map[string]sekai.Validators) error {
+	for url, v := range validators {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO pjsk_fetch_cache (region, url, etag, last_modified, updated_at)
+			VALUES ($1,$2,$3,$4, now())
+			ON CONFLICT (region, url) DO UPDATE SET
+			  etag=EXCLUDED.etag,
+			  last_modified=EXCLUDED.last_modified,
+			  updated_at=now()
+		`, region, url, v.ETag, v.LastModified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// masterFile 是一个待拉取的 master 文件，fetch 成功时把结果写进目标切片
+type masterFile struct {
+	name  string
+	url   string
+	fetch func(ctx context.Context, prev sekai.Validators) (sekai.Validators, error)
+}
+
+func masterFileOf[T any](hc *httpx.Client, name, url string, dst *[]T) masterFile {
+	return masterFile{
+		name: name,
+		url:  url,
+		fetch: func(ctx context.Context, prev sekai.Validators) (sekai.Validators, error) {
+			st, err := sekai.OpenStream[T](ctx, hc, url, prev)
+			if err != nil {
+				return sekai.Validators{}, err
+			}
+			defer st.Close()
+
+			items, err := sekai.Collect(st)
+			if err != nil {
+				return sekai.Validators{}, err
+			}
+			*dst = items
+			return st.Validators, nil
+		},
+	}
+}
+
+// spooledFile 是下载到本地临时文件的 master 文件。大文件不整体载入内存：
+// 校验时流式读一遍（见 screenFile），落库时再流式读一遍，只 COPY 没有被剔除的记录。
+type spooledFile[T sekai.Record] struct {
+	path   string
+	screen *sekai.Screen[T] // screenFile 之后才有
+}
+
+func spoolFileOf[T sekai.Record](hc *httpx.Client, name, url, dir string, dst *spooledFile[T]) masterFile {
+	return masterFile{
+		name: name,
+		url:  url,
+		fetch: func(ctx context.Context, prev sekai.Validators) (sekai.Validators, error) {
+			path, v, err := sekai.Spool(ctx, hc, url, prev, dir)
+			if err != nil {
+				return sekai.Validators{}, err
+			}
+			*dst = spooledFile[T]{path: path}
+			return v, nil
+		},
+	}
+}
+
+// records 按顺序读出通过校验的记录；读取 / 解码失败时产出一次错误后结束
+func (f *spooledFile[T]) records() iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		st, err := sekai.OpenFile[T](f.path)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		defer st.Close()
+
+		i := 0
+		for v, err := range st.All() {
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			skip := f.screen != nil && f.screen.Skip(i)
+			i++
+			if !skip && !yield(v, nil) {
+				return
+			}
+		}
+	}
+}
+
+// fetchGroup 条件拉取一组互相引用的文件。全部 304 时返回 false，组内什么都不落库；
+// 只要有一个变了，其余 304 的文件无条件重拉，保证落库时整组数据完整。
+func fetchGroup(ctx context.Context, cache fetchCache, got map[string]sekai.Validators, files ...masterFile) (bool, error) {
+	var stale []masterFile
+	for _, f := range files {
+		v, err := f.fetch(ctx, cache[f.url])
+		if errors.Is(err, sekai.ErrNotModified) {
+			stale = append(stale, f)
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("fetch %s: %w", f.name, err)
+		}
+		got[f.url] = v
+	}
+	if len(stale) == len(files) {
+		return false, nil
+	}
+
+	for _, f := range stale {
+		v, err := f.fetch(ctx, sekai.Validators{})
+		if err != nil {
+			return false, fmt.Errorf("fetch %s: %w", f.name, err)
+		}
+		got[f.url] = v
+	}
+	return true, nil
+}
+
+// 摘要只保留素材任务、reconcile 和外键过滤用到的字段（与 loadAssetInputs 从库里读的一致），
+// 整个同步期间常驻内存的只有这些
+func cardSummary(c sekai.Card) sekai.Card {
+	return sekai.Card{
+		ID: c.ID, CharacterID: c.CharacterID, CardRarityType: c.CardRarityType,
+		AssetbundleName: c.AssetbundleName, ReleaseAt: c.ReleaseAt,
+	}
+}
+
+func gachaSummary(g sekai.Gacha) sekai.Gacha { return sekai.Gacha{ID: g.ID} }
+
+func eventSummary(e sekai.Event) sekai.Event {
+	return sekai.Event{ID: e.ID, AssetbundleName: e.AssetbundleName}
+}
+
+// loadAssetInputs 从库里取生成素材任务所需的最少字段（卡面 / 活动 / 卡池未变化、没有拉取时使用）
+func loadAssetInputs(ctx context.Context, pool *pgxpool.Pool, m *regionMaster) error {
+	region := m.src.Region
+
+	// 发布时间没有单独建列，从 raw 里取（新卡缩略图策略要用）
+	rows, err := pool.Query(ctx, `
+		SELECT id, rarity, assetbundle_name, COALESCE((raw->>'releaseAt')::bigint, 0)
+		FROM pjsk_cards WHERE region=$1 AND deleted_at IS NULL
+	`, region)
+	if err != nil {
+		return err
+	}
+	m.cards, err = pgx.CollectRows(rows, func(r pgx.CollectableRow) (sekai.Card, error) {
+		var c sekai.Card
+		err := r.Scan(&c.ID, &c.CardRarityType, &c.AssetbundleName, &c.ReleaseAt)
+		return c, err
+	})
+	if err != nil {
+		return err
+	}
+
+	rows, err = pool.Query(ctx, `SELECT id, assetbundle_name FROM pjsk_events WHERE region=$1 AND deleted_at IS NULL`, region)
+	if err != nil {
+		return err
+	}
+	m.events, err = pgx.CollectRows(rows, func(r pgx.CollectableRow) (sekai.Event, error) {
+		var e sekai.Event
+		err := r.Scan(&e.ID, &e.AssetbundleName)
+		return e, err
+	})
+	if err != nil {
+		return err
+	}
+
+	rows, err = pool.Query(ctx, `SELECT id FROM pjsk_gachas WHERE region=$1 AND deleted_at IS NULL`, region)
+	if err != nil {
+		return err
+	}
+	m.gachas, err = pgx.CollectRows(rows, func(r pgx.CollectableRow) (sekai.Gacha, error) {
+		var g sekai.Gacha
+		err := r.Scan(&g.ID)
+		return g, err
+	})
+	return err
+}
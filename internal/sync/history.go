@@ -0,0 +1,52 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// 每次 Run 在 pjsk_sync_runs 记一行，pjsk_change_log 里的变更都挂在它下面。
+// q 通常是连接池（run 行不随同步事务回滚）；基准测试里传事务
+func beginSyncRun(ctx context.Context, q interface {
+	QueryRow(context.Context, string, ...any) pgx.Row
+}) (int64, error) {
+	var id int64
+	err := q.QueryRow(ctx, `INSERT INTO pjsk_sync_runs (started_at, status) VALUES (now(), 'running') RETURNING id`).Scan(&id)
+	return id, err
+}
+
+// syncRun 随落库流程传递：id 是 pjsk_sync_runs.id；演练时 dryRun 为真、id 为 0，
+// 各写入点只对比临时表与正式表、统计将会发生的改动
+type syncRun struct {
+	id     int64
+	dryRun bool
+}
+
+func finishSyncRun(ctx context.Context, pool *pgxpool.Pool, runID int64, runErr error) {
+	status, msg := "ok", ""
+	if runErr != nil {
+		status, msg = "failed", runErr.Error()
+	}
+	// ctx 可能已被信号取消，收尾仍要写进去
+	if _, err := pool.Exec(context.WithoutCancel(ctx),
+		`UPDATE pjsk_sync_runs SET finished_at=now(), status=$2, error=$3 WHERE id=$1`,
+		runID, status, msg,
+	); err != nil {
+		log.Printf("warn: finish sync run %d failed: %v", runID, err)
+	}
+}
+
+// upsertCounts 是单个实体一次同步的写入结果
+type upsertCounts struct {
+	Inserted  int `json:"inserted"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+}
+
+func (c upsertCounts) String() string {
+	return fmt.Sprintf("+%d ~%d =%d", c.Inserted, c.Updated, c.Unchanged)
+}
@@ -0,0 +1,97 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"pjsk-sync/internal/config"
+)
+
+// 素材失败策略；cmd/pjsk-sync 按它们给出不同的退出码
+const (
+	PolicyWriteError       = "write_error"        // 下载到的素材写盘失败（ASSET_FAIL_ON_WRITE_ERROR）
+	PolicyMissLimit        = "miss_limit"         // 缺失数 / 缺失比例超限（ASSET_MAX_MISSES / ASSET_MAX_MISS_PERCENT）
+	PolicyNewCardThumbnail = "new_card_thumbnail" // 新发布的卡面缺缩略图（ASSET_NEW_CARD_WINDOW）
+)
+
+// 错误信息里最多列出这么多条路径
+const maxPolicyPaths = 5
+
+// AssetFailure 表示某个服务器的素材阶段触发了失败策略
+type AssetFailure struct {
+	Region string
+	Policy string
+	Detail string
+}
+
+func (e *AssetFailure) Error() string {
+	return fmt.Sprintf("assets [%s]: %s: %s", e.Region, e.Policy, e.Detail)
+}
+
+// checkAssetPolicies 按配置检查一个服务器素材阶段的结果，触发的策略按
+// 写盘失败、缺失超限、新卡缺图的顺序合并返回
+func checkAssetPolicies(cfg config.Config, region string, jobs []assetJob, rr *RegionReport, now time.Time) error {
+	t := rr.assetTotals()
+	var errs []error
+
+	if cfg.AssetFailOnWriteError && t.Failed > 0 {
+		var paths []string
+		for _, m := range rr.Misses {
+			if m.Outcome == assetFailed {
+				paths = append(paths, m.Path)
+			}
+		}
+		errs = append(errs, &AssetFailure{Region: region, Policy: PolicyWriteError,
+			Detail: fmt.Sprintf("%d assets could not be written: %s", t.Failed, listPaths(paths))})
+	}
+
+	// 转不成 webp 的是 upstream 的问题（多半返回的不是图片），与下载失败一样算缺失；
+	// 比例的分母是本次需要下载的任务：已存在 / 从 png 迁移的不算
+	missed := t.Missed + t.Invalid
+	attempted := t.Saved + missed + t.Failed
+	var pct float64
+	if attempted > 0 {
+		pct = float64(missed) * 100 / float64(attempted)
+	}
+	switch {
+	case cfg.AssetMaxMisses >= 0 && missed > cfg.AssetMaxMisses:
+		errs = append(errs, &AssetFailure{Region: region, Policy: PolicyMissLimit,
+			Detail: fmt.Sprintf("%d of %d downloads missed, limit is %d", missed, attempted, cfg.AssetMaxMisses)})
+	case cfg.AssetMaxMissPercent >= 0 && pct > cfg.AssetMaxMissPercent:
+		errs = append(errs, &AssetFailure{Region: region, Policy: PolicyMissLimit,
+			Detail: fmt.Sprintf("%d of %d downloads missed (%.1f%%), limit is %g%%",
+				missed, attempted, pct, cfg.AssetMaxMissPercent)})
+	}
+
+	if cfg.AssetNewCardWindow > 0 {
+		missing := map[string]bool{}
+		for _, m := range rr.Misses {
+			missing[m.Path] = true
+		}
+		// 发布时间在 (now-window, now] 内的卡面；还没发布的卡素材站多半还没有，不算
+		since := now.Add(-cfg.AssetNewCardWindow).UnixMilli()
+		var paths []string
+		for _, j := range jobs {
+			if j.kind == "card_normal" && j.releaseAt > since && j.releaseAt <= now.UnixMilli() && missing[j.destRel] {
+				paths = append(paths, j.destRel)
+			}
+		}
+		if len(paths) > 0 {
+			errs = append(errs, &AssetFailure{Region: region, Policy: PolicyNewCardThumbnail,
+				Detail: fmt.Sprintf("%d cards released within %s have no thumbnail: %s", len(paths), cfg.AssetNewCardWindow, listPaths(paths))})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func listPaths(paths []string) string {
+	slices.Sort(paths)
+	if len(paths) > maxPolicyPaths {
+		return fmt.Sprintf("%s, ... (%d more)", strings.Join(paths[:maxPolicyPaths], ", "), len(paths)-maxPolicyPaths)
+	}
+	return strings.Join(paths, ", ")
+}
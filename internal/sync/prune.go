@@ -0,0 +1,78 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"pjsk-sync/internal/config"
+	"pjsk-sync/internal/db"
+)
+
+// PruneResult 是 Prune 删除的行数
+type PruneResult struct {
+	Tombstones int64 // deleted_at 过期的记录（含级联前手动清理的引用）
+	Runs       int64 // 同步历史（change log 随 run 级联删除）
+}
+
+// Prune 物理删除 deleted_at 早于 now-olderThan 的墓碑行，以及同样早于该时间结束的同步历史。
+// 与同步共用 advisory lock，避免和正在进行的同步交错。
+func Prune(ctx context.Context, pool *pgxpool.Pool, cfg config.Config, olderThan time.Duration) (PruneResult, error) {
+	var res PruneResult
+	if olderThan <= 0 {
+		return res, fmt.Errorf("prune: retention must be positive")
+	}
+	cutoff := time.Now().Add(-olderThan)
+
+	lock, err := db.AcquireAdvisoryLock(ctx, pool, syncLockKey, cfg.LockWait, cfg.LockWaitTimeout)
+	if err != nil {
+		return res, fmt.Errorf("acquire sync lock: %w", err)
+	}
+	defer lock.Release(ctx)
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback(ctx)
+
+	// 卡池 / 活动 / 歌曲的子表是 ON DELETE CASCADE；卡面没有级联，先清掉对过期卡面的引用
+	stmts := []struct {
+		name string
+		sql  string
+	}{
+		{"pjsk_gachas", `DELETE FROM pjsk_gachas WHERE deleted_at < $1`},
+		{"pjsk_events", `DELETE FROM pjsk_events WHERE deleted_at < $1`},
+		{"pjsk_musics", `DELETE FROM pjsk_musics WHERE deleted_at < $1`},
+		{"pjsk_gacha_pickups", `DELETE FROM pjsk_gacha_pickups p USING pjsk_cards c
+			WHERE p.region = c.region AND p.card_id = c.id AND c.deleted_at < $1`},
+		{"pjsk_event_cards", `DELETE FROM pjsk_event_cards e USING pjsk_cards c
+			WHERE e.region = c.region AND e.card_id = c.id AND c.deleted_at < $1`},
+		{"pjsk_cards", `DELETE FROM pjsk_cards WHERE deleted_at < $1`},
+	}
+	for _, st := range stmts {
+		tag, err := tx.Exec(ctx, st.sql, cutoff)
+		if err != nil {
+			return res, fmt.Errorf("prune %s: %w", st.name, err)
+		}
+		if n := tag.RowsAffected(); n > 0 {
+			log.Printf("prune: %s -%d", st.name, n)
+			res.Tombstones += n
+		}
+	}
+
+	tag, err := tx.Exec(ctx, `DELETE FROM pjsk_sync_runs WHERE finished_at < $1`, cutoff)
+	if err != nil {
+		return res, fmt.Errorf("prune pjsk_sync_runs: %w", err)
+	}
+	res.Runs = tag.RowsAffected()
+
+	if err := tx.Commit(ctx); err != nil {
+		return res, err
+	}
+	log.Printf("prune: removed %d tombstoned rows and %d sync runs older than %s", res.Tombstones, res.Runs, cutoff.Format(time.RFC3339))
+	return res, nil
+}
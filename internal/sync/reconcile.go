@@ -0,0 +1,135 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"pjsk-sync/internal/sekai"
+)
+
+// reconcileRemoved 处理 upstream master 中已不存在的记录：默认写 deleted_at（墓碑），
+// hardDelete 时直接删除（连同引用它们的子表记录）。每行都以 op=delete 记入 pjsk_change_log，
+// 返回本次新标记 / 删除的行数。
+// keep 是按表名列出的额外存活 id（校验未通过被隔离的记录：仍在 upstream，库里的旧行不动）。
+// 演练时只统计将被处理的行。
+func reconcileRemoved(ctx context.Context, tx pgx.Tx, run syncRun, region string, hardDelete bool,
+	cards []sekai.Card, gachas []sekai.Gacha, events []sekai.Event, musics []sekai.Music, keep map[string][]int) (int64, error) {
+	cardIDs := make([]int, 0, len(cards))
+	for _, c := range cards {
+		cardIDs = append(cardIDs, c.ID)
+	}
+	gachaIDs := make([]int, 0, len(gachas))
+	for _, g := range gachas {
+		gachaIDs = append(gachaIDs, g.ID)
+	}
+	eventIDs := make([]int, 0, len(events))
+	for _, e := range events {
+		eventIDs = append(eventIDs, e.ID)
+	}
+	musicIDs := make([]int, 0, len(musics))
+	for _, m := range musics {
+		musicIDs = append(musicIDs, m.ID)
+	}
+
+	targets := []struct {
+		table string
+		ids   []int
+	}{
+		{"pjsk_gachas", gachaIDs},
+		{"pjsk_events", eventIDs},
+		{"pjsk_musics", musicIDs},
+		{"pjsk_cards", cardIDs},
+	}
+	// 空列表多半是 upstream 文件出了问题，而不是真的全部下架：对应的表不做任何处理
+	alive := map[string][]int{}
+	for _, t := range targets {
+		if len(t.ids) > 0 {
+			alive[t.table] = append(t.ids, keep[t.table]...)
+		}
+	}
+
+	var total int64
+	// 硬删除时先显式删掉引用被删行的子表记录（否则卡面的外键会挡住删除，卡池 / 活动 / 歌曲的
+	// 子表会被级联删掉），这样每一行都记进 change log
+	if hardDelete {
+		for _, c := range dependents {
+			var conds []string
+			var params []any
+			for _, ref := range c.refs {
+				if ids, ok := alive[ref.parent]; ok {
+					params = append(params, ids)
+					conds = append(conds, fmt.Sprintf("NOT (%s = ANY($%d))", ref.column, len(params)+1))
+				}
+			}
+			if len(conds) == 0 {
+				continue
+			}
+			n, err := removeRows(ctx, tx, run, region, c.table, c.key, strings.Join(conds, " OR "), params, false)
+			if err != nil {
+				return total, fmt.Errorf("%s: %w", c.table, err)
+			}
+			total += n
+		}
+	}
+
+	for _, t := range targets {
+		ids, ok := alive[t.table]
+		if !ok {
+			continue
+		}
+		n, err := removeRows(ctx, tx, run, region, t.table, "id::text", "NOT (id = ANY($2))", []any{ids}, !hardDelete)
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", t.table, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// dependent 是外键指向 reconcile 目标表的子表；key 是写进 change log 的 entity_key
+type dependent struct {
+	table string
+	key   string
+	refs  []fkRef
+}
+
+type fkRef struct{ column, parent string }
+
+var dependents = []dependent{
+	{"pjsk_gacha_pickups", "gacha_id::text || ':' || card_id::text", []fkRef{{"gacha_id", "pjsk_gachas"}, {"card_id", "pjsk_cards"}}},
+	{"pjsk_event_cards", "id::text", []fkRef{{"event_id", "pjsk_events"}, {"card_id", "pjsk_cards"}}},
+	{"pjsk_event_deck_bonuses", "id::text", []fkRef{{"event_id", "pjsk_events"}}},
+	{"pjsk_music_difficulties", "id::text", []fkRef{{"music_id", "pjsk_musics"}}},
+}
+
+// removeRows 删除（tombstone 时标记墓碑）该服务器里满足 cond 的行，每行以 op=delete 记入 change log。
+// $1 是 region，params 依次是 cond 里的 $2、$3……；演练时只统计，见 planRemoved。
+func removeRows(ctx context.Context, tx pgx.Tx, run syncRun, region, table, key, cond string, params []any, tombstone bool) (int64, error) {
+	if tombstone {
+		cond = "deleted_at IS NULL AND (" + cond + ")"
+	}
+	if run.dryRun {
+		return planRemoved(ctx, tx, region, table, key, cond, params)
+	}
+	args := append([]any{region}, params...)
+
+	remove := fmt.Sprintf(`DELETE FROM %s WHERE region=$1 AND (%s)`, table, cond)
+	if tombstone {
+		remove = fmt.Sprintf(`UPDATE %s SET deleted_at=now() WHERE region=$1 AND (%s)`, table, cond)
+	}
+	tag, err := tx.Exec(ctx, fmt.Sprintf(`
+		WITH gone AS (
+			%[1]s
+			RETURNING region, %[3]s AS entity_key, to_jsonb(%[2]s) - 'updated_at' - 'deleted_at' AS j
+		)
+		INSERT INTO pjsk_change_log (run_id, region, entity, entity_key, op, before, after)
+		SELECT $%[4]d::bigint, region, '%[2]s', entity_key, 'delete', j, NULL FROM gone
+	`, remove, table, key, len(args)+1), append(args, run.id)...)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
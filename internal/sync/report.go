@@ -0,0 +1,295 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Markdown 里每个服务器最多列出这么多条素材缺失（JSON 里是全量的）；
+// GitHub step summary 有大小上限
+const maxReportMisses = 50
+
+// Report 是一次 Run 的结构化结果：写成 JSON 便于前后两次 diff，写成 Markdown 贴进 step summary。
+// 演练时所有计数都是"将会发生"的数量。
+type Report struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	DurationMS int64     `json:"durationMs"`
+	DryRun     bool      `json:"dryRun"`
+	Phases     Phases    `json:"phases"`
+	RunID      int64     `json:"runId,omitempty"` // pjsk_sync_runs.id；没跑数据库阶段或演练时为 0
+	DBMS       int64     `json:"dbMs"`            // 所有服务器共用一个事务，只有总耗时
+
+	Regions []*RegionReport `json:"regions"`
+	Errors  []string        `json:"errors,omitempty"`
+}
+
+// RegionReport 是单个服务器的结果
+type RegionReport struct {
+	Region       string `json:"region"`
+	DataVersion  string `json:"dataVersion,omitempty"`
+	AssetVersion string `json:"assetVersion,omitempty"`
+
+	// 跳过原因；为空表示该阶段执行了
+	DBSkipped     string `json:"dbSkipped,omitempty"`
+	AssetsSkipped string `json:"assetsSkipped,omitempty"`
+
+	// 只含本次实际落库的实体；NotModified 列出 upstream 未变化而跳过的文件组
+	Entities    map[string]upsertCounts `json:"entities,omitempty"`
+	NotModified []string                `json:"notModified,omitempty"`
+	Removed     int64                   `json:"removed"`
+	Quarantined int                     `json:"quarantined"`
+
+	Assets map[string]*AssetCounts `json:"assets,omitempty"` // 按素材种类，见 assetJob.kind
+	Misses []AssetMiss             `json:"misses,omitempty"`
+
+	FetchMS  int64 `json:"fetchMs"`
+	AssetsMS int64 `json:"assetsMs"`
+
+	mu sync.Mutex // 素材 goroutine 并发写 Assets / Misses
+}
+
+// AssetCounts 是一种素材的处理结果
+type AssetCounts struct {
+	Total    int `json:"total"`
+	Existing int `json:"existing"` // 已存在，跳过
+	Saved    int `json:"saved"`    // 新下载
+	Migrated int `json:"migrated"` // 旧 png 转成 webp
+	Missed   int `json:"missed"`   // 所有候选 URL 都没拿到
+	Invalid  int `json:"invalid"`  // 拿到了但转不成 webp（upstream 返回的不是图片等）
+	Failed   int `json:"failed"`   // 写盘失败
+}
+
+// AssetMiss 记录一个没能落盘的素材；Outcome 是 missed / invalid / failed，后两者的原因在 Error 里
+type AssetMiss struct {
+	Path     string         `json:"path"`
+	Kind     string         `json:"kind"`
+	Outcome  string         `json:"outcome"`
+	Attempts []AssetAttempt `json:"attempts,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// AssetAttempt 是对一个候选 URL 的一次请求
+type AssetAttempt struct {
+	URL    string `json:"url"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// 素材处理结果，对应 AssetCounts 的字段
+const (
+	assetExisting = "existing"
+	assetSaved    = "saved"
+	assetMigrated = "migrated"
+	assetMissed   = "missed"
+	assetInvalid  = "invalid"
+	assetFailed   = "failed"
+)
+
+func newReport(dryRun bool, phases Phases) *Report {
+	return &Report{StartedAt: time.Now(), DryRun: dryRun, Phases: phases}
+}
+
+func (r *Report) region(name string) *RegionReport {
+	rr := &RegionReport{Region: name}
+	r.Regions = append(r.Regions, rr)
+	return rr
+}
+
+func (r *Report) finish(err error) {
+	r.FinishedAt = time.Now()
+	r.DurationMS = r.FinishedAt.Sub(r.StartedAt).Milliseconds()
+	// 素材是并发处理的，缺失列表按路径排序，前后两次的报告才能直接 diff
+	for _, rr := range r.Regions {
+		slices.SortFunc(rr.Misses, func(a, b AssetMiss) int { return strings.Compare(a.Path, b.Path) })
+	}
+	if err != nil {
+		r.Errors = append(r.Errors, err.Error())
+	}
+}
+
+func (rr *RegionReport) asset(kind, outcome string) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.Assets == nil {
+		rr.Assets = map[string]*AssetCounts{}
+	}
+	c := rr.Assets[kind]
+	if c == nil {
+		c = &AssetCounts{}
+		rr.Assets[kind] = c
+	}
+	c.Total++
+	switch outcome {
+	case assetExisting:
+		c.Existing++
+	case assetSaved:
+		c.Saved++
+	case assetMigrated:
+		c.Migrated++
+	case assetMissed:
+		c.Missed++
+	case assetInvalid:
+		c.Invalid++
+	case assetFailed:
+		c.Failed++
+	}
+}
+
+func (rr *RegionReport) miss(m AssetMiss) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.Misses = append(rr.Misses, m)
+}
+
+// assetTotals 汇总所有种类
+func (rr *RegionReport) assetTotals() AssetCounts {
+	var t AssetCounts
+	for _, c := range rr.Assets {
+		t.Total += c.Total
+		t.Existing += c.Existing
+		t.Saved += c.Saved
+		t.Migrated += c.Migrated
+		t.Missed += c.Missed
+		t.Invalid += c.Invalid
+		t.Failed += c.Failed
+	}
+	return t
+}
+
+// WriteFiles 把报告写到 jsonPath / mdPath；路径为空的跳过
+func (r *Report) WriteFiles(jsonPath, mdPath string) error {
+	if jsonPath != "" {
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeReportFile(jsonPath, append(b, '\n')); err != nil {
+			return err
+		}
+	}
+	if mdPath != "" {
+		if err := writeReportFile(mdPath, []byte(r.Markdown())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeReportFile(path string, data []byte) error {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Markdown 渲染成 GitHub step summary 用的表格
+func (r *Report) Markdown() string {
+	var b strings.Builder
+	title := "pjsk-sync report"
+	if r.DryRun {
+		title += " (dry run)"
+	}
+	status := "ok"
+	if len(r.Errors) > 0 {
+		status = "failed"
+	}
+	fmt.Fprintf(&b, "## %s\n\n", title)
+	fmt.Fprintf(&b, "- status: **%s**\n", status)
+	fmt.Fprintf(&b, "- started: %s, took %s\n", r.StartedAt.UTC().Format(time.RFC3339), time.Duration(r.DurationMS)*time.Millisecond)
+	fmt.Fprintf(&b, "- phases: db=%t assets=%t\n", r.Phases.DB, r.Phases.Assets)
+	if r.RunID != 0 {
+		fmt.Fprintf(&b, "- sync run: %d (db %s)\n", r.RunID, time.Duration(r.DBMS)*time.Millisecond)
+	}
+	for _, e := range r.Errors {
+		fmt.Fprintf(&b, "- error: `%s`\n", mdEscape(e))
+	}
+
+	for _, rr := range r.Regions {
+		fmt.Fprintf(&b, "\n### %s\n\n", rr.Region)
+		if rr.DataVersion != "" || rr.AssetVersion != "" {
+			fmt.Fprintf(&b, "dataVersion `%s`, assetVersion `%s`. ", orNone(rr.DataVersion), orNone(rr.AssetVersion))
+		}
+		fmt.Fprintf(&b, "fetch %s, assets %s.\n\n",
+			time.Duration(rr.FetchMS)*time.Millisecond, time.Duration(rr.AssetsMS)*time.Millisecond)
+
+		if rr.DBSkipped != "" {
+			fmt.Fprintf(&b, "db skipped: %s\n\n", rr.DBSkipped)
+		} else {
+			b.WriteString("| entity | inserted | updated | unchanged |\n|---|--:|--:|--:|\n")
+			for _, name := range slices.Sorted(maps.Keys(rr.Entities)) {
+				c := rr.Entities[name]
+				fmt.Fprintf(&b, "| %s | %d | %d | %d |\n", name, c.Inserted, c.Updated, c.Unchanged)
+			}
+			fmt.Fprintf(&b, "\nremoved: %d, quarantined: %d", rr.Removed, rr.Quarantined)
+			if len(rr.NotModified) > 0 {
+				fmt.Fprintf(&b, ", not modified upstream: %s", strings.Join(rr.NotModified, ", "))
+			}
+			b.WriteString("\n\n")
+		}
+
+		if rr.AssetsSkipped != "" {
+			fmt.Fprintf(&b, "assets skipped: %s\n", rr.AssetsSkipped)
+			continue
+		}
+		b.WriteString("| asset | total | existing | saved | migrated | missed | invalid | failed |\n|---|--:|--:|--:|--:|--:|--:|--:|\n")
+		for _, kind := range slices.Sorted(maps.Keys(rr.Assets)) {
+			writeAssetRow(&b, kind, *rr.Assets[kind])
+		}
+		writeAssetRow(&b, "**total**", rr.assetTotals())
+
+		if len(rr.Misses) > 0 {
+			fmt.Fprintf(&b, "\n<details><summary>%d missing assets</summary>\n\n", len(rr.Misses))
+			b.WriteString("| path | kind | attempts |\n|---|---|---|\n")
+			for i, m := range rr.Misses {
+				if i == maxReportMisses {
+					fmt.Fprintf(&b, "| ... %d more | | |\n", len(rr.Misses)-i)
+					break
+				}
+				fmt.Fprintf(&b, "| `%s` | %s | %s |\n", m.Path, m.Kind, mdEscape(describeAttempts(m)))
+			}
+			b.WriteString("\n</details>\n")
+		}
+	}
+	return b.String()
+}
+
+func writeAssetRow(b *strings.Builder, kind string, c AssetCounts) {
+	fmt.Fprintf(b, "| %s | %d | %d | %d | %d | %d | %d | %d |\n", kind, c.Total, c.Existing, c.Saved, c.Migrated, c.Missed, c.Invalid, c.Failed)
+}
+
+func describeAttempts(m AssetMiss) string {
+	if m.Error != "" {
+		return m.Error
+	}
+	parts := make([]string, 0, len(m.Attempts))
+	for _, a := range m.Attempts {
+		switch {
+		case a.Error != "":
+			parts = append(parts, fmt.Sprintf("%s (%s)", a.URL, a.Error))
+		default:
+			parts = append(parts, fmt.Sprintf("%s (%d)", a.URL, a.Status))
+		}
+	}
+	return strings.Join(parts, "<br>")
+}
+
+func mdEscape(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
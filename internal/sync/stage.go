@@ -0,0 +1,182 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// stagedEntity 描述一次批量写入：行先 COPY 进临时表，再用一条 INSERT ... SELECT ... ON CONFLICT 合并。
+type stagedEntity struct {
+	table     string
+	key       string   // 除 region 外的主键列
+	columns   []string // 写入列，必须以 region, key 开头；不含 updated_at / deleted_at
+	rows      *rowSource
+	tombstone bool // 表上有 deleted_at：重新出现的行要清掉墓碑
+}
+
+func stageTable(table string) string { return "stage_" + table }
+
+// rowSource 在 COPY 时才逐条把记录转换成行，内存里不会再攒一份 [][]any
+type rowSource struct {
+	next func() ([]any, bool)
+	stop func()
+	cur  []any
+	err  error // 读流失败：CopyFrom 通过 Err 拿到后中止 COPY
+}
+
+// stageRows 把 items 映射成 COPY 行；row 返回 nil 表示跳过该条
+func stageRows[T any](items iter.Seq[T], row func(T) []any) *rowSource {
+	return stageStream(func(yield func(T, error) bool) {
+		for it := range items {
+			if !yield(it, nil) {
+				return
+			}
+		}
+	}, row)
+}
+
+// stageStream 同 stageRows，items 是边读边解码的流（见 spooledFile.records）
+func stageStream[T any](items iter.Seq2[T, error], row func(T) []any) *rowSource {
+	s := &rowSource{}
+	s.next, s.stop = iter.Pull(func(yield func([]any) bool) {
+		for it, err := range items {
+			if err != nil {
+				s.err = err
+				return
+			}
+			if r := row(it); r != nil && !yield(r) {
+				return
+			}
+		}
+	})
+	return s
+}
+
+func (s *rowSource) Next() bool {
+	var ok bool
+	s.cur, ok = s.next()
+	return ok
+}
+
+func (s *rowSource) Values() ([]any, error) { return s.cur, nil }
+func (s *rowSource) Err() error             { return s.err }
+
+// 经临时表写入的表
+var stagedTables = []string{
+	"pjsk_units", "pjsk_characters",
+	"pjsk_cards", "pjsk_gachas", "pjsk_gacha_pickups", "pjsk_events", "pjsk_event_cards", "pjsk_event_deck_bonuses",
+	"pjsk_musics", "pjsk_music_difficulties",
+}
+
+// createStageTables 在事务开头为 stagedTables 建同结构的临时表，事务结束时删除。
+// 演练的只读事务里不能建表，所以统一在切换为只读之前建好。
+func createStageTables(ctx context.Context, tx pgx.Tx) error {
+	for _, table := range stagedTables {
+		if _, err := tx.Exec(ctx, fmt.Sprintf(
+			`CREATE TEMP TABLE %s (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP`, stageTable(table), table,
+		)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyToStage 清空 table 的临时表并 COPY 进去，返回写入行数
+func copyToStage(ctx context.Context, tx pgx.Tx, table string, columns []string, rows pgx.CopyFromSource) (int64, error) {
+	stage := stageTable(table)
+	// 同一事务内多个服务器复用同一张临时表；只读事务不允许 TRUNCATE
+	if _, err := tx.Exec(ctx, fmt.Sprintf(`DELETE FROM %s`, stage)); err != nil {
+		return 0, err
+	}
+	n, err := tx.CopyFrom(ctx, pgx.Identifier{stage}, columns, rows)
+	if err != nil {
+		return 0, fmt.Errorf("copy %s: %w", stage, err)
+	}
+	return n, nil
+}
+
+// mergeStaged 把 e.rows 合并进 e.table：内容未变的行不写（updated_at 保持不动），
+// 新增 / 变化的行同一快照下对比旧值写入 pjsk_change_log。演练时只统计，见 planStaged。
+func mergeStaged(ctx context.Context, tx pgx.Tx, run syncRun, e stagedEntity) (upsertCounts, error) {
+	counts := upsertCounts{}
+	defer e.rows.stop()
+	staged, err := copyToStage(ctx, tx, e.table, e.columns, e.rows)
+	if err != nil {
+		return counts, err
+	}
+	if staged == 0 {
+		return counts, nil
+	}
+	if run.dryRun {
+		return planStaged(ctx, tx, e, staged)
+	}
+
+	cols := strings.Join(e.columns, ", ")
+	var sets, oldVals, newVals []string
+	for _, c := range e.columns[2:] {
+		sets = append(sets, fmt.Sprintf("%s=EXCLUDED.%s", c, c))
+		oldVals = append(oldVals, e.table+"."+c)
+		newVals = append(newVals, "EXCLUDED."+c)
+	}
+	sets = append(sets, "updated_at=now()")
+	if e.tombstone {
+		sets = append(sets, "deleted_at=NULL")
+		oldVals = append(oldVals, e.table+".deleted_at")
+		newVals = append(newVals, "NULL::timestamptz")
+	}
+
+	var inserted, updated int
+	err = tx.QueryRow(ctx, fmt.Sprintf(`
+		WITH old AS (
+			SELECT t.%[2]s AS k, to_jsonb(t) - 'updated_at' AS j
+			FROM %[1]s t JOIN %[3]s s ON t.region = s.region AND t.%[2]s = s.%[2]s
+		), new AS (
+			INSERT INTO %[1]s (%[4]s, updated_at)
+			SELECT %[4]s, now() FROM %[3]s
+			ON CONFLICT (region, %[2]s) DO UPDATE SET %[5]s
+			WHERE (%[6]s) IS DISTINCT FROM (%[7]s)
+			RETURNING region, %[2]s AS k, to_jsonb(%[1]s) - 'updated_at' AS j
+		), logged AS (
+			INSERT INTO pjsk_change_log (run_id, region, entity, entity_key, op, before, after)
+			SELECT $1::bigint, new.region, '%[1]s', new.k::text,
+			       CASE WHEN old.j IS NULL THEN 'insert' ELSE 'update' END, old.j, new.j
+			FROM new LEFT JOIN old ON old.k = new.k
+			WHERE old.j IS DISTINCT FROM new.j
+			RETURNING op
+		)
+		SELECT count(*) FILTER (WHERE op = 'insert'), count(*) FILTER (WHERE op = 'update') FROM logged
+	`, e.table, e.key, stageTable(e.table), cols,
+		strings.Join(sets, ", "), strings.Join(oldVals, ", "), strings.Join(newVals, ", "),
+	), run.id).Scan(&inserted, &updated)
+	if err != nil {
+		return counts, fmt.Errorf("merge %s: %w", e.table, err)
+	}
+
+	counts.Inserted = inserted
+	counts.Updated = updated
+	counts.Unchanged = int(staged) - inserted - updated
+	return counts, nil
+}
+
+// dedupeLast 按 key 去重并保留最后一次出现的记录：
+// INSERT ... SELECT ... ON CONFLICT 不允许同一语句两次更新同一行，而逐行 upsert 时是后者覆盖前者。
+func dedupeLast[T any, K comparable](items []T, key func(T) K) []T {
+	last := make(map[K]int, len(items))
+	for i, it := range items {
+		last[key(it)] = i
+	}
+	if len(last) == len(items) {
+		return items
+	}
+	out := make([]T, 0, len(last))
+	for i, it := range items {
+		if last[key(it)] == i {
+			out = append(out, it)
+		}
+	}
+	return out
+}
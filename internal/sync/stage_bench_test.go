@@ -0,0 +1,195 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"pjsk-sync/internal/db"
+	"pjsk-sync/internal/sekai"
+)
+
+// 对比卡面的两种写入方式：改造前逐行 upsert 的 pgx.Batch，与现在的 COPY 临时表 + 单条合并。
+// 需要一个本地 Postgres（会执行迁移，数据都写在回滚的事务里）：
+//
+//	PJSK_BENCH_DSN=postgres://localhost/pjsk_bench go test ./internal/sync -run '^$' -bench Upsert
+const benchRegion = "bench"
+
+func benchPool(b *testing.B) *pgxpool.Pool {
+	dsn := os.Getenv("PJSK_BENCH_DSN")
+	if dsn == "" {
+		b.Skip("PJSK_BENCH_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := db.Open(ctx, dsn, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(pool.Close)
+	if err := db.Migrate(ctx, pool); err != nil {
+		b.Fatal(err)
+	}
+	return pool
+}
+
+func benchCards(n int) []sekai.Card {
+	cards := make([]sekai.Card, n)
+	for i := range cards {
+		c := sekai.Card{
+			ID:              i + 1,
+			CharacterID:     1,
+			CardRarityType:  "rarity_4",
+			Attr:            "cool",
+			Prefix:          fmt.Sprintf("prefix %d", i),
+			AssetbundleName: fmt.Sprintf("res%03d_no%03d", i%26, i),
+		}
+		c.Raw, _ = json.Marshal(map[string]any{
+			"id": c.ID, "characterId": c.CharacterID, "cardRarityType": c.CardRarityType,
+			"attr": c.Attr, "prefix": c.Prefix, "assetbundleName": c.AssetbundleName,
+		})
+		cards[i] = c
+	}
+	return cards
+}
+
+// benchTx 开一个事务，建好临时表并写好卡面依赖的团体 / 角色和 run 行；seed 为真时先把 cards 写进去（测"未变"路径）
+func benchTx(b *testing.B, pool *pgxpool.Pool, cards []sekai.Card, seed bool) (pgx.Tx, syncRun) {
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO pjsk_units (region, unit, unit_name, seq) VALUES ($1, 'idol', 'idol', 1)`, benchRegion); err != nil {
+		b.Fatal(err)
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO pjsk_characters (region, id, seq, resource_id, unit) VALUES ($1, 1, 1, 1, 'idol')`, benchRegion); err != nil {
+		b.Fatal(err)
+	}
+	if err := createStageTables(ctx, tx); err != nil {
+		b.Fatal(err)
+	}
+	var run syncRun
+	if run.id, err = beginSyncRun(ctx, tx); err != nil {
+		b.Fatal(err)
+	}
+	if seed {
+		if _, err := upsertCards(ctx, tx, run, benchRegion, withoutErrors(cards), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return tx, run
+}
+
+func benchUpsert(b *testing.B, seed bool, upsert func(context.Context, pgx.Tx, syncRun, []sekai.Card) (upsertCounts, error)) {
+	pool := benchPool(b)
+	for _, n := range []int{500, 5000} {
+		cards := benchCards(n)
+		b.Run(fmt.Sprintf("cards=%d", n), func(b *testing.B) {
+			ctx := context.Background()
+			for b.Loop() {
+				b.StopTimer()
+				tx, run := benchTx(b, pool, cards, seed)
+				b.StartTimer()
+
+				counts, err := upsert(ctx, tx, run, cards)
+
+				b.StopTimer()
+				if err != nil {
+					b.Fatal(err)
+				}
+				got := counts.Inserted
+				if seed {
+					got = counts.Unchanged
+				}
+				if got != n {
+					b.Fatalf("unexpected counts %s for %d cards", counts, n)
+				}
+				_ = tx.Rollback(ctx)
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func stagedUpsert(ctx context.Context, tx pgx.Tx, run syncRun, cards []sekai.Card) (upsertCounts, error) {
+	return upsertCards(ctx, tx, run, benchRegion, withoutErrors(cards), nil)
+}
+
+// withoutErrors 让内存里的切片当作 upsertCards 的输入流；两条路径都不计解码开销
+func withoutErrors[T any](items []T) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for _, it := range items {
+			if !yield(it, nil) {
+				return
+			}
+		}
+	}
+}
+
+func BenchmarkUpsertCardsStagedInsert(b *testing.B)    { benchUpsert(b, false, stagedUpsert) }
+func BenchmarkUpsertCardsStagedUnchanged(b *testing.B) { benchUpsert(b, true, stagedUpsert) }
+func BenchmarkUpsertCardsBatchInsert(b *testing.B)     { benchUpsert(b, false, batchUpsertCards) }
+func BenchmarkUpsertCardsBatchUnchanged(b *testing.B)  { benchUpsert(b, true, batchUpsertCards) }
+
+// batchUpsertCards 是改造前的写法（逐行 INSERT ... ON CONFLICT，每行一条带 change log 的 CTE），
+// 只为基准对比保留；raw 列一并写入，两边的工作量一致
+func batchUpsertCards(ctx context.Context, tx pgx.Tx, run syncRun, cards []sekai.Card) (upsertCounts, error) {
+	batch := &pgx.Batch{}
+	for _, c := range cards {
+		batch.Queue(batchCardUpsert, benchRegion, c.ID, c.CharacterID, c.Attr, c.Prefix, c.CardRarityType, c.AssetbundleName, c.Raw, run.id)
+	}
+
+	var counts upsertCounts
+	br := tx.SendBatch(ctx, batch)
+	defer br.Close()
+	for range batch.Len() {
+		var inserted, updated int
+		if err := br.QueryRow().Scan(&inserted, &updated); err != nil {
+			return counts, err
+		}
+		counts.Inserted += inserted
+		counts.Updated += updated
+		if inserted == 0 && updated == 0 {
+			counts.Unchanged++
+		}
+	}
+	return counts, nil
+}
+
+const batchCardUpsert = `
+	WITH old AS (
+		SELECT to_jsonb(t) - 'updated_at' AS j FROM pjsk_cards t WHERE t.region=$1 AND t.id=$2
+	), new AS (
+		INSERT INTO pjsk_cards (region, id, character_id, attr, prefix, rarity, assetbundle_name, raw, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8, now())
+		ON CONFLICT (region, id) DO UPDATE SET
+		  character_id=EXCLUDED.character_id,
+		  attr=EXCLUDED.attr,
+		  prefix=EXCLUDED.prefix,
+		  rarity=EXCLUDED.rarity,
+		  assetbundle_name=EXCLUDED.assetbundle_name,
+		  raw=EXCLUDED.raw,
+		  updated_at=now(),
+		  deleted_at=NULL
+		WHERE (pjsk_cards.character_id, pjsk_cards.attr, pjsk_cards.prefix, pjsk_cards.rarity,
+		       pjsk_cards.assetbundle_name, pjsk_cards.raw, pjsk_cards.deleted_at)
+		  IS DISTINCT FROM
+		      (EXCLUDED.character_id, EXCLUDED.attr, EXCLUDED.prefix, EXCLUDED.rarity,
+		       EXCLUDED.assetbundle_name, EXCLUDED.raw, NULL::timestamptz)
+		RETURNING region, id::text AS entity_key, to_jsonb(pjsk_cards) - 'updated_at' AS j
+	), logged AS (
+		INSERT INTO pjsk_change_log (run_id, region, entity, entity_key, op, before, after)
+		SELECT $9::bigint, new.region, 'pjsk_cards', new.entity_key,
+		       CASE WHEN old.j IS NULL THEN 'insert' ELSE 'update' END, old.j, new.j
+		FROM new LEFT JOIN old ON true
+		WHERE old.j IS DISTINCT FROM new.j
+		RETURNING op
+	)
+	SELECT count(*) FILTER (WHERE op = 'insert'), count(*) FILTER (WHERE op = 'update') FROM logged
+`
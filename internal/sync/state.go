@@ -0,0 +1,62 @@
+package sync
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// syncState 是某个服务器上次成功同步时 versions.json 的版本
+type syncState struct {
+	DataVersion  string
+	AssetVersion string
+}
+
+func loadSyncStates(ctx context.Context, pool *pgxpool.Pool) (map[string]syncState, error) {
+	rows, err := pool.Query(ctx, `SELECT region, data_version, asset_version FROM pjsk_sync_state`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := map[string]syncState{}
+	for rows.Next() {
+		var region string
+		var st syncState
+		if err := rows.Scan(&region, &st.DataVersion, &st.AssetVersion); err != nil {
+			return nil, err
+		}
+		states[region] = st
+	}
+	return states, rows.Err()
+}
+
+// 空版本号说明 versions.json 里没有这个字段：视为不同，避免永远跳过
+func sameVersion(cur, prev string) bool {
+	return cur != "" && cur == prev
+}
+
+// data_version 随落库事务提交
+func saveDataVersion(ctx context.Context, tx pgx.Tx, region, version string) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO pjsk_sync_state (region, data_version, data_synced_at)
+		VALUES ($1,$2, now())
+		ON CONFLICT (region) DO UPDATE SET
+		  data_version=EXCLUDED.data_version,
+		  data_synced_at=now()
+	`, region, version)
+	return err
+}
+
+// asset_version 在素材阶段完成后单独写入
+func saveAssetVersion(ctx context.Context, pool *pgxpool.Pool, region, version string) error {
+	_, err := pool.Exec(ctx, `
+		INSERT INTO pjsk_sync_state (region, asset_version, assets_synced_at)
+		VALUES ($1,$2, now())
+		ON CONFLICT (region) DO UPDATE SET
+		  asset_version=EXCLUDED.asset_version,
+		  assets_synced_at=now()
+	`, region, version)
+	return err
+}
@@ -0,0 +1,60 @@
+package sync
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RegionStatus 是某个服务器上次同步到的版本
+type RegionStatus struct {
+	Region         string
+	DataVersion    string
+	AssetVersion   string
+	DataSyncedAt   *time.Time
+	AssetsSyncedAt *time.Time
+}
+
+// RunStatus 是 pjsk_sync_runs 中的一次同步及其变更条数
+type RunStatus struct {
+	ID         int64
+	StartedAt  time.Time
+	FinishedAt *time.Time
+	Status     string
+	Error      string
+	Changes    int64
+}
+
+func RegionStatuses(ctx context.Context, pool *pgxpool.Pool) ([]RegionStatus, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT region, data_version, asset_version, data_synced_at, assets_synced_at
+		FROM pjsk_sync_state ORDER BY region
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(r pgx.CollectableRow) (RegionStatus, error) {
+		var s RegionStatus
+		err := r.Scan(&s.Region, &s.DataVersion, &s.AssetVersion, &s.DataSyncedAt, &s.AssetsSyncedAt)
+		return s, err
+	})
+}
+
+// RecentRuns 返回最近 limit 次同步，新的在前
+func RecentRuns(ctx context.Context, pool *pgxpool.Pool, limit int) ([]RunStatus, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT r.id, r.started_at, r.finished_at, r.status, coalesce(r.error, ''),
+		       (SELECT count(*) FROM pjsk_change_log l WHERE l.run_id = r.id)
+		FROM pjsk_sync_runs r ORDER BY r.id DESC LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(r pgx.CollectableRow) (RunStatus, error) {
+		var s RunStatus
+		err := r.Scan(&s.ID, &s.StartedAt, &s.FinishedAt, &s.Status, &s.Error, &s.Changes)
+		return s, err
+	})
+}
@@ -2,21 +2,31 @@ package sync
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"iter"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"pjsk-sync/internal/assets"
 	"pjsk-sync/internal/config"
+	"pjsk-sync/internal/db"
+	"pjsk-sync/internal/httpx"
 	"pjsk-sync/internal/sekai"
 )
 
+// 所有 pjsk-sync 进程共用的同步锁 key（"pjsksync"）
+const syncLockKey = 0x706a736b_73796e63
+
 func msToSec(ms int64) int64 {
 	if ms <= 0 {
 		return 0
@@ -60,107 +70,553 @@ func classifyGacha(g sekai.Gacha) (category string, rarity4 *float32, birthday *
 	return "other", r4, rb
 }
 
-func Run(ctx context.Context, pool *pgxpool.Pool, cfg config.Config) error {
-	// 1) fetch master
-	cards, err := sekai.FetchJSON[[]sekai.Card](ctx, cfg.CardsURL)
-	if err != nil {
-		return fmt.Errorf("fetch cards: %w", err)
+// Phases 选择 Run 执行哪些阶段
+type Phases struct {
+	DB     bool `json:"db"`     // 拉 master 并落库
+	Assets bool `json:"assets"` // 下载素材到 IMAGE_REPO_DIR
+}
+
+// Run 执行一次同步。pool 为 nil 时只能跑素材阶段：不做条件拉取和版本比对，素材任务直接由 master 生成。
+// 无论成败都返回 Report，并写到 REPORT_JSON / REPORT_MARKDOWN（若配置了）。
+func Run(ctx context.Context, pool *pgxpool.Pool, cfg config.Config, phases Phases) (rep *Report, err error) {
+	rep = newReport(cfg.DryRun, phases)
+	defer func() {
+		rep.finish(err)
+		if werr := rep.WriteFiles(cfg.ReportJSON, cfg.ReportMarkdown); werr != nil {
+			log.Printf("warn: write report: %v", werr)
+		}
+	}()
+
+	if len(cfg.Regions) == 0 {
+		return rep, fmt.Errorf("no regions enabled (REGIONS is empty)")
+	}
+	if phases.DB && pool == nil {
+		return rep, fmt.Errorf("db phase needs a database connection")
 	}
-	gachas, err := sekai.FetchJSON[[]sekai.Gacha](ctx, cfg.GachasURL)
+
+	var caches map[string]fetchCache
+	states := map[string]syncState{}
+	if pool != nil {
+		// --force 也要绕过条件拉取：304 的文件同样会被跳过，库里漂移的行修不回来
+		if cfg.ConditionalFetch && phases.DB && !cfg.Force {
+			if caches, err = loadFetchCache(ctx, pool); err != nil {
+				return rep, fmt.Errorf("load fetch cache: %w", err)
+			}
+		}
+		if states, err = loadSyncStates(ctx, pool); err != nil {
+			return rep, fmt.Errorf("load sync state: %w", err)
+		}
+	}
+	hc, err := httpx.New(cfg.HTTP)
 	if err != nil {
-		return fmt.Errorf("fetch gachas: %w", err)
+		return rep, fmt.Errorf("http client: %w", err)
 	}
-	events, err := sekai.FetchJSON[[]sekai.Event](ctx, cfg.EventsURL)
+	// 大的 master 文件先落到这里，校验和落库时各流式读一遍
+	spool, err := os.MkdirTemp("", "pjsk-sync-")
 	if err != nil {
-		return fmt.Errorf("fetch events: %w", err)
+		return rep, fmt.Errorf("spool dir: %w", err)
+	}
+	defer os.RemoveAll(spool)
+
+	// 1) fetch master（不占锁）
+	masters := make([]regionMaster, 0, len(cfg.Regions))
+	var pending int
+	var quarantine []quarantined
+	defer func() {
+		if err := writeQuarantineReport(cfg.QuarantineReport, quarantine); err != nil {
+			log.Printf("warn: write quarantine report: %v", err)
+		}
+	}()
+	for _, src := range cfg.Regions {
+		rr := rep.region(src.Region)
+		started := time.Now()
+		m, err := planRegion(ctx, hc, cfg, src, states[src.Region], caches[src.Region], spool, phases, pool != nil)
+		rr.FetchMS = time.Since(started).Milliseconds()
+		quarantine = append(quarantine, m.quarantine...)
+		rr.Quarantined = len(m.quarantine)
+		if err != nil {
+			return rep, fmt.Errorf("region %s: %w", src.Region, err)
+		}
+		// 卡面 / 活动 / 卡池未变时不会拉取，素材任务改用库里已有的记录生成，之前缺失的素材仍会重试
+		if !m.skipAssets && !m.gachaChanged {
+			if err := loadAssetInputs(ctx, pool, &m); err != nil {
+				return rep, fmt.Errorf("region %s: load asset inputs: %w", src.Region, err)
+			}
+		}
+		if !m.skipDB {
+			pending++
+		}
+		m.report = rr
+		describePlan(rr, m, phases)
+		masters = append(masters, m)
 	}
 
-	// 2) upsert db
-	cardToChar := make(map[int]int, len(cards))
-	if err := upsertCards(ctx, pool, cards, cardToChar); err != nil {
-		return err
+	// 2) upsert db：整段持有 advisory lock，避免与其他进程的同步交错
+	if pending > 0 {
+		started := time.Now()
+		err := syncDB(ctx, pool, cfg, masters, rep)
+		rep.DBMS = time.Since(started).Milliseconds()
+		for _, m := range masters {
+			quarantine = append(quarantine, m.dropped...)
+			m.report.Quarantined += len(m.dropped)
+		}
+		if err != nil {
+			return rep, err
+		}
+	} else if phases.DB {
+		log.Printf("db: all regions up to date, skipped")
 	}
-	if err := upsertGachasAndPickups(ctx, pool, gachas, cardToChar); err != nil {
-		return err
+
+	// 3) assets to local image repo (incremental)
+	var assetFailures []error
+	for _, m := range masters {
+		if m.skipAssets {
+			if phases.Assets {
+				log.Printf("assets [%s]: assetVersion %s unchanged, skipped", m.src.Region, m.version.AssetVersion)
+			}
+			continue
+		}
+		started := time.Now()
+		err := syncAssetsToDir(ctx, hc, cfg, m.src, m.cards, m.events, m.gachas, m.report)
+		m.report.AssetsMS = time.Since(started).Milliseconds()
+		// 触发失败策略时其余服务器照常处理；assetVersion 不写回，下次不会因版本未变而跳过
+		var failure *AssetFailure
+		if errors.As(err, &failure) {
+			assetFailures = append(assetFailures, err)
+			continue
+		}
+		if err != nil {
+			return rep, fmt.Errorf("region %s: %w", m.src.Region, err)
+		}
+		// 有素材没落盘时同样不写回，否则在 upstream 换版本之前都不会再重试
+		if t := m.report.assetTotals(); t.Missed+t.Invalid+t.Failed > 0 {
+			log.Printf("assets [%s]: %d assets not stored, assetVersion not saved", m.src.Region, t.Missed+t.Invalid+t.Failed)
+			continue
+		}
+		if m.version != nil && pool != nil && !cfg.DryRun {
+			if err := saveAssetVersion(ctx, pool, m.src.Region, m.version.AssetVersion); err != nil {
+				return rep, fmt.Errorf("region %s: save asset version: %w", m.src.Region, err)
+			}
+		}
 	}
-	if err := upsertEvents(ctx, pool, events); err != nil {
-		return err
+
+	return rep, errors.Join(assetFailures...)
+}
+
+// describePlan 把版本号和各阶段的跳过原因记进报告
+func describePlan(rr *RegionReport, m regionMaster, phases Phases) {
+	if m.version != nil {
+		rr.DataVersion, rr.AssetVersion = m.version.DataVersion, m.version.AssetVersion
+	}
+	switch {
+	case !phases.DB:
+		rr.DBSkipped = "phase not selected"
+	case m.skipDB:
+		rr.DBSkipped = "dataVersion unchanged"
 	}
+	switch {
+	case !phases.Assets:
+		rr.AssetsSkipped = "phase not selected"
+	case m.skipAssets:
+		rr.AssetsSkipped = "assetVersion unchanged"
+	}
+}
 
-	log.Printf("db synced: cards=%d gachas=%d events=%d", len(cards), len(gachas), len(events))
+// planRegion 先比对 versions.json（若配置了），数据版本未变就不拉 master
+func planRegion(ctx context.Context, hc *httpx.Client, cfg config.Config, src config.RegionSource, prev syncState, cache fetchCache, spool string, phases Phases, haveDB bool) (regionMaster, error) {
+	var version *sekai.Versions
+	if src.VersionURL != "" {
+		v, err := sekai.FetchJSON[sekai.Versions](ctx, hc, src.VersionURL)
+		if err != nil {
+			return regionMaster{}, fmt.Errorf("fetch versions: %w", err)
+		}
+		version = &v
+	}
 
-	// 3) assets to local image repo (incremental)
-	if cfg.DownloadAssets {
-		if err := syncAssetsToDir(ctx, cfg, cards, events, gachas); err != nil {
-			return err
+	dataUnchanged := version != nil && !cfg.Force && sameVersion(version.DataVersion, prev.DataVersion)
+	assetsUnchanged := version != nil && !cfg.Force && sameVersion(version.AssetVersion, prev.AssetVersion)
+	skipDB := !phases.DB || dataUnchanged
+	skipAssets := !phases.Assets || assetsUnchanged
+
+	if phases.DB && dataUnchanged {
+		log.Printf("db [%s]: dataVersion %s unchanged, skipped", src.Region, version.DataVersion)
+	}
+
+	m := regionMaster{src: src}
+	// 没有数据库时素材任务只能由 master 生成
+	if !skipDB || (!skipAssets && !haveDB) {
+		var err error
+		if m, err = fetchRegion(ctx, hc, src, cache, spool); err != nil {
+			return m, err
+		}
+		if err := validateRegion(&m, cfg.MaxInvalidPercent); err != nil {
+			return m, err
 		}
 	}
+	m.version, m.skipDB, m.skipAssets = version, skipDB, skipAssets
+	return m, nil
+}
 
-	return nil
+// regionMaster 是单个服务器本次拉取到的全部 master 数据。
+// 互相引用的文件按组拉取（见 fetchGroup），未变化的组对应字段为空、*Changed 为 false。
+type regionMaster struct {
+	src config.RegionSource
+
+	profilesChanged bool
+	units           []sekai.UnitProfile
+	characters      []sekai.GameCharacter
+
+	// 卡面 / 卡池 / 活动文件较大，不整体载入：*File 是落在本地的原文件，
+	// cards / gachas / events 只是校验后精简过的摘要（见 cardSummary 等），落库时从 *File 流式读取
+	gachaChanged bool
+	cardsFile    spooledFile[sekai.Card]
+	gachasFile   spooledFile[sekai.Gacha]
+	eventsFile   spooledFile[sekai.Event]
+	cards        []sekai.Card
+	gachas       []sekai.Gacha
+	events       []sekai.Event
+	eventCards   []sekai.EventCard
+	deckBonuses  []sekai.EventDeckBonus
+
+	musicChanged bool
+	musics       []sekai.Music
+	difficulties []sekai.MusicDifficulty
+
+	// 本次 200 响应的校验头，落库成功后写回 pjsk_fetch_cache
+	validators map[string]sekai.Validators
+
+	// versions.json 的内容；未配置 VersionURL 时为 nil，此时从不跳过
+	version    *sekai.Versions
+	skipDB     bool
+	skipAssets bool
+
+	// 校验未通过、没有落库的记录
+	quarantine []quarantined
+	// 落库时因外键目标不存在而跳过的记录：角色不存在的卡面、卡面不存在的卡池 pickup
+	// （目标被隔离且库里也没有时）
+	dropped []quarantined
+
+	report *RegionReport
 }
 
-func upsertCards(ctx context.Context, pool *pgxpool.Pool, cards []sekai.Card, cardToChar map[int]int) error {
-	batch := &pgx.Batch{}
-	for _, c := range cards {
-		cardToChar[c.ID] = c.CharacterID
-		batch.Queue(`
-			INSERT INTO pjsk_cards (id, character_id, attr, prefix, rarity, assetbundle_name, updated_at)
-			VALUES ($1,$2,$3,$4,$5,$6, now())
-			ON CONFLICT (id) DO UPDATE SET
-			  character_id=EXCLUDED.character_id,
-			  attr=EXCLUDED.attr,
-			  prefix=EXCLUDED.prefix,
-			  rarity=EXCLUDED.rarity,
-			  assetbundle_name=EXCLUDED.assetbundle_name,
-			  updated_at=now()
-		`, c.ID, c.CharacterID, c.Attr, c.Prefix, c.CardRarityType, c.AssetbundleName)
-	}
-	br := pool.SendBatch(ctx, batch)
-	defer br.Close()
-	for range cards {
-		if _, err := br.Exec(); err != nil {
-			return err
-		}
+func fetchRegion(ctx context.Context, hc *httpx.Client, src config.RegionSource, cache fetchCache, spool string) (regionMaster, error) {
+	m := regionMaster{src: src, validators: map[string]sekai.Validators{}}
+	var err error
+
+	m.profilesChanged, err = fetchGroup(ctx, cache, m.validators,
+		masterFileOf(hc, "unit profiles", src.UnitProfilesURL, &m.units),
+		masterFileOf(hc, "game characters", src.GameCharactersURL, &m.characters),
+	)
+	if err != nil {
+		return m, err
 	}
-	return nil
+	m.gachaChanged, err = fetchGroup(ctx, cache, m.validators,
+		spoolFileOf(hc, "cards", src.CardsURL, spool, &m.cardsFile),
+		spoolFileOf(hc, "gachas", src.GachasURL, spool, &m.gachasFile),
+		spoolFileOf(hc, "events", src.EventsURL, spool, &m.eventsFile),
+		masterFileOf(hc, "event cards", src.EventCardsURL, &m.eventCards),
+		masterFileOf(hc, "event deck bonuses", src.EventDeckBonusesURL, &m.deckBonuses),
+	)
+	if err != nil {
+		return m, err
+	}
+	m.musicChanged, err = fetchGroup(ctx, cache, m.validators,
+		masterFileOf(hc, "musics", src.MusicsURL, &m.musics),
+		masterFileOf(hc, "music difficulties", src.MusicDifficultiesURL, &m.difficulties),
+	)
+	if err != nil {
+		return m, err
+	}
+	return m, nil
 }
 
-func upsertGachasAndPickups(ctx context.Context, pool *pgxpool.Pool, gachas []sekai.Gacha, cardToChar map[int]int) error {
+func syncDB(ctx context.Context, pool *pgxpool.Pool, cfg config.Config, masters []regionMaster, rep *Report) (err error) {
+	// 演练只读，不必与其他进程的同步互斥
+	if !cfg.DryRun {
+		lock, err := db.AcquireAdvisoryLock(ctx, pool, syncLockKey, cfg.LockWait, cfg.LockWaitTimeout)
+		if err != nil {
+			return fmt.Errorf("acquire sync lock: %w", err)
+		}
+		defer lock.Release(ctx)
+	}
+
+	// 所有服务器、所有实体在同一个事务里：读者要么看到上一次同步的结果，要么看到这一次的，
+	// 不会看到卡面已更新而卡池仍旧的中间状态；任何一步失败整体回滚
 	tx, err := pool.Begin(ctx)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = tx.Rollback(ctx) }()
+	defer func() { _ = tx.Rollback(context.WithoutCancel(ctx)) }()
 
-	for _, g := range gachas {
-		category, r4, rb := classifyGacha(g)
+	// 只读事务里不能建表，临时表要先建好
+	if err := createStageTables(ctx, tx); err != nil {
+		return fmt.Errorf("create stage tables: %w", err)
+	}
+
+	// 演练时事务只读：只往临时表里 COPY，再与正式表对比计数；不写 run 行，也不消耗序列
+	run := syncRun{dryRun: cfg.DryRun}
+	if cfg.DryRun {
+		if _, err := tx.Exec(ctx, `SET TRANSACTION READ ONLY`); err != nil {
+			return err
+		}
+	} else {
+		if run.id, err = beginSyncRun(ctx, pool); err != nil {
+			return fmt.Errorf("begin sync run: %w", err)
+		}
+		defer func() { finishSyncRun(ctx, pool, run.id, err) }()
+		log.Printf("sync run %d started", run.id)
+		rep.RunID = run.id
+	}
+
+	for i := range masters {
+		m := &masters[i]
+		if m.skipDB {
+			continue
+		}
+		if err := syncRegionDB(ctx, tx, cfg, run, m); err != nil {
+			return fmt.Errorf("region %s: %w", m.src.Region, err)
+		}
+		if m.version != nil && !run.dryRun {
+			if err := saveDataVersion(ctx, tx, m.src.Region, m.version.DataVersion); err != nil {
+				return fmt.Errorf("region %s: save data version: %w", m.src.Region, err)
+			}
+		}
+	}
+
+	if run.dryRun {
+		logPlannedTotal(masters)
+		return nil
+	}
+
+	// 校验头与数据同事务提交：落库失败时下次仍会完整拉取
+	for _, m := range masters {
+		if err := saveFetchCache(ctx, tx, m.src.Region, m.validators); err != nil {
+			return fmt.Errorf("save fetch cache: %w", err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func syncRegionDB(ctx context.Context, tx pgx.Tx, cfg config.Config, run syncRun, m *regionMaster) error {
+	region := m.src.Region
+
+	var (
+		unitCounts, characterCounts                                            upsertCounts
+		cardCounts, gachaCounts, eventCounts, eventCardCounts, deckBonusCounts upsertCounts
+		musicCounts, difficultyCounts                                          upsertCounts
+		err                                                                    error
+	)
 
-		_, err := tx.Exec(ctx, `
-			INSERT INTO pjsk_gachas
-			  (id, gacha_type, name, seq, assetbundle_name, start_at, end_at, pool_category, rarity4_rate, birthday_rate, updated_at)
-			VALUES
-			  ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10, now())
-			ON CONFLICT (id) DO UPDATE SET
-			  gacha_type=EXCLUDED.gacha_type,
-			  name=EXCLUDED.name,
-			  seq=EXCLUDED.seq,
-			  assetbundle_name=EXCLUDED.assetbundle_name,
-			  start_at=EXCLUDED.start_at,
-			  end_at=EXCLUDED.end_at,
-			  pool_category=EXCLUDED.pool_category,
-			  rarity4_rate=EXCLUDED.rarity4_rate,
-			  birthday_rate=EXCLUDED.birthday_rate,
-			  updated_at=now()
-		`, g.ID, g.GachaType, g.Name, g.Seq, g.AssetbundleName, msToSec(g.StartAt), msToSec(g.EndAt), category, r4, rb)
+	// 角色 / 团体必须先落库：卡面与卡池 pickup 的 character_id 外键指向它们
+	if m.profilesChanged {
+		unitCounts, err = upsertUnits(ctx, tx, run, region, m.units)
+		if err != nil {
+			return err
+		}
+		characterCounts, err = upsertCharacters(ctx, tx, run, region, m.characters, m.units)
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.gachaChanged {
+		// character_id 外键指向 pjsk_characters：角色被隔离或未收录时跳过该卡面，
+		// 否则一条记录就会让整个事务（所有服务器）回滚
+		knownChars, err := storedIDs(ctx, tx, "pjsk_characters", region)
+		if err != nil {
+			return err
+		}
+		for _, c := range m.characters {
+			knownChars[c.ID] = true // 演练时本次的角色没有写入
+		}
+		cardToChar := make(map[int]int, len(m.cards))
+		skipCards := map[int]bool{}
+		for _, c := range m.cards {
+			if !knownChars[c.CharacterID] {
+				key := strconv.Itoa(c.ID)
+				m.drop(sekai.Invalid{
+					Entity: "cards",
+					Index:  m.cardsFile.screen.Index(key),
+					Key:    key,
+					Reason: fmt.Sprintf("character %d is not stored", c.CharacterID),
+				})
+				skipCards[c.ID] = true
+				continue
+			}
+			cardToChar[c.ID] = c.CharacterID
+		}
+		cardCounts, err = upsertCards(ctx, tx, run, region, m.cardsFile.records(), skipCards)
+		if err != nil {
+			return err
+		}
+		// 被隔离 / 跳过的卡面不在 cardToChar 里，但库里可能已有旧版本，引用它的记录仍可落库
+		knownCards, err := storedIDs(ctx, tx, "pjsk_cards", region)
+		if err != nil {
+			return err
+		}
+		for id := range cardToChar {
+			knownCards[id] = true
+		}
+		var dropped []sekai.Invalid
+		gachaCounts, dropped, err = upsertGachasAndPickups(ctx, tx, run, region, m.gachasFile.records(), cardToChar, knownCards)
+		if err != nil {
+			return err
+		}
+		for _, d := range dropped {
+			m.drop(d)
+		}
+		eventCounts, err = upsertEvents(ctx, tx, run, region, m.eventsFile.records())
+		if err != nil {
+			return err
+		}
+		eventCardCounts, err = upsertEventCards(ctx, tx, run, region, m.eventCards, m.events, knownCards)
+		if err != nil {
+			return err
+		}
+		deckBonusCounts, err = upsertEventDeckBonuses(ctx, tx, run, region, m.deckBonuses, m.events)
 		if err != nil {
 			return err
 		}
+	}
 
-		if _, err := tx.Exec(ctx, `DELETE FROM pjsk_gacha_pickups WHERE gacha_id=$1`, g.ID); err != nil {
+	if m.musicChanged {
+		musicCounts, err = upsertMusics(ctx, tx, run, region, m.musics)
+		if err != nil {
 			return err
 		}
+		difficultyCounts, err = upsertMusicDifficulties(ctx, tx, run, region, m.difficulties, m.musics)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 未变化的组传入空切片，reconcileRemoved 会跳过（m.cards 等此时可能是 loadAssetInputs 从库里读的）
+	cards, gachas, events := m.cards, m.gachas, m.events
+	if !m.gachaChanged {
+		cards, gachas, events = nil, nil, nil
+	}
+	removed, err := reconcileRemoved(ctx, tx, run, region, cfg.HardDelete, cards, gachas, events, m.musics, quarantinedIDs(m.quarantine))
+	if err != nil {
+		return fmt.Errorf("reconcile removed: %w", err)
+	}
+
+	// 计数格式：+新增 ~更新 =未变
+	log.Printf("db synced [%s]: units=[%s] characters=[%s] cards=[%s] gachas=[%s] events=[%s] event_cards=[%s] event_deck_bonuses=[%s] musics=[%s] music_difficulties=[%s] removed=%d",
+		region, unitCounts, characterCounts, cardCounts, gachaCounts, eventCounts, eventCardCounts, deckBonusCounts, musicCounts, difficultyCounts, removed)
+	if !m.profilesChanged || !m.gachaChanged || !m.musicChanged {
+		log.Printf("db [%s]: not modified upstream, skipped: profiles=%t cards/gachas/events=%t musics=%t",
+			region, !m.profilesChanged, !m.gachaChanged, !m.musicChanged)
+	}
+
+	rr := m.report
+	rr.Entities, rr.Removed = map[string]upsertCounts{}, removed
+	if m.profilesChanged {
+		rr.Entities["units"], rr.Entities["characters"] = unitCounts, characterCounts
+	} else {
+		rr.NotModified = append(rr.NotModified, "profiles")
+	}
+	if m.gachaChanged {
+		rr.Entities["cards"], rr.Entities["gachas"], rr.Entities["events"] = cardCounts, gachaCounts, eventCounts
+		rr.Entities["event_cards"], rr.Entities["event_deck_bonuses"] = eventCardCounts, deckBonusCounts
+	} else {
+		rr.NotModified = append(rr.NotModified, "cards/gachas/events")
+	}
+	if m.musicChanged {
+		rr.Entities["musics"], rr.Entities["music_difficulties"] = musicCounts, difficultyCounts
+	} else {
+		rr.NotModified = append(rr.NotModified, "musics")
+	}
+
+	return nil
+}
+
+func upsertUnits(ctx context.Context, tx pgx.Tx, run syncRun, region string, units []sekai.UnitProfile) (upsertCounts, error) {
+	units = dedupeLast(units, func(u sekai.UnitProfile) string { return u.Unit })
+	rows := stageRows(slices.Values(units), func(u sekai.UnitProfile) []any {
+		return []any{region, u.Unit, u.UnitName, u.Seq, u.ProfileSentence, u.ColorCode, u.Raw}
+	})
+	return mergeStaged(ctx, tx, run, stagedEntity{
+		table:   "pjsk_units",
+		key:     "unit",
+		columns: []string{"region", "unit", "unit_name", "seq", "profile_sentence", "color_code", "raw"},
+		rows:    rows,
+	})
+}
+
+func upsertCharacters(ctx context.Context, tx pgx.Tx, run syncRun, region string, characters []sekai.GameCharacter, units []sekai.UnitProfile) (upsertCounts, error) {
+	// unit 外键指向 pjsk_units：未收录的团体写 NULL，而不是让整批失败
+	known := make(map[string]bool, len(units))
+	for _, u := range units {
+		known[u.Unit] = true
+	}
+
+	characters = dedupeLast(characters, func(c sekai.GameCharacter) int { return c.ID })
+	rows := stageRows(slices.Values(characters), func(c sekai.GameCharacter) []any {
+		var unit any
+		if known[c.Unit] {
+			unit = c.Unit
+		}
+		return []any{
+			region, c.ID, c.Seq, c.ResourceID, c.FirstName, c.GivenName, c.FirstNameRuby, c.GivenNameRuby,
+			c.Gender, unit, c.SupportUnitType, c.Raw,
+		}
+	})
+	return mergeStaged(ctx, tx, run, stagedEntity{
+		table: "pjsk_characters",
+		key:   "id",
+		columns: []string{
+			"region", "id", "seq", "resource_id", "first_name", "given_name", "first_name_ruby", "given_name_ruby",
+			"gender", "unit", "support_unit_type", "raw",
+		},
+		rows: rows,
+	})
+}
+
+// cards 是校验过的流：重复 id 已在 screenFile 时剔除；skip 中的 id 不写
+func upsertCards(ctx context.Context, tx pgx.Tx, run syncRun, region string, cards iter.Seq2[sekai.Card, error], skip map[int]bool) (upsertCounts, error) {
+	rows := stageStream(cards, func(c sekai.Card) []any {
+		if skip[c.ID] {
+			return nil
+		}
+		return []any{region, c.ID, c.CharacterID, c.Attr, c.Prefix, c.CardRarityType, c.AssetbundleName, c.Raw}
+	})
+	return mergeStaged(ctx, tx, run, stagedEntity{
+		table:     "pjsk_cards",
+		key:       "id",
+		columns:   []string{"region", "id", "character_id", "attr", "prefix", "rarity", "assetbundle_name", "raw"},
+		rows:      rows,
+		tombstone: true,
+	})
+}
+
+// upsertGachasAndPickups 写入卡池及其 pickup。pickup 的 card_id 外键指向 pjsk_cards，
+// 引用 knownCards 以外卡面的 pickup 跳过并返回，以免整个事务失败。
+func upsertGachasAndPickups(ctx context.Context, tx pgx.Tx, run syncRun, region string, gachas iter.Seq2[sekai.Gacha, error], cardToChar map[int]int, knownCards map[int]bool) (upsertCounts, []sekai.Invalid, error) {
+	// pickup 很小，在 COPY 卡池的同时收集，卡池合并完再整组写入
+	var gachaIDs []int
+	type pickupKey struct{ gachaID, cardID int }
+	seen := map[pickupKey]bool{}
+	var pickups [][]any
+	var dropped []sekai.Invalid
+
+	rows := stageStream(gachas, func(g sekai.Gacha) []any {
+		gachaIDs = append(gachaIDs, g.ID)
+		for i, p := range g.GachaPickups {
+			k := pickupKey{g.ID, p.CardID}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			if !knownCards[p.CardID] {
+				dropped = append(dropped, sekai.Invalid{
+					Entity: "gachaPickups",
+					Index:  i,
+					Key:    fmt.Sprintf("%d:%d", g.ID, p.CardID),
+					Reason: fmt.Sprintf("card %d is not stored", p.CardID),
+				})
+				continue
+			}
 
-		for _, p := range g.GachaPickups {
 			ch := cardToChar[p.CardID]
 			var chAny any
 			if ch != 0 {
@@ -168,44 +624,68 @@ func upsertGachasAndPickups(ctx context.Context, pool *pgxpool.Pool, gachas []se
 			} else {
 				chAny = nil
 			}
-			if _, err := tx.Exec(ctx, `
-				INSERT INTO pjsk_gacha_pickups (gacha_id, card_id, character_id)
-				VALUES ($1,$2,$3)
-				ON CONFLICT (gacha_id, card_id) DO UPDATE SET character_id=EXCLUDED.character_id
-			`, g.ID, p.CardID, chAny); err != nil {
-				return err
-			}
+			pickups = append(pickups, []any{region, g.ID, p.CardID, chAny})
 		}
+
+		category, r4, rb := classifyGacha(g)
+		return []any{region, g.ID, g.GachaType, g.Name, g.Seq, g.AssetbundleName, msToSec(g.StartAt), msToSec(g.EndAt), category, r4, rb, g.Raw}
+	})
+	counts, err := mergeStaged(ctx, tx, run, stagedEntity{
+		table: "pjsk_gachas",
+		key:   "id",
+		columns: []string{
+			"region", "id", "gacha_type", "name", "seq", "assetbundle_name", "start_at", "end_at",
+			"pool_category", "rarity4_rate", "birthday_rate", "raw",
+		},
+		rows:      rows,
+		tombstone: true,
+	})
+	if err != nil {
+		return counts, nil, err
+	}
+	// pickup 不计数，也不进 change log，演练时没什么可统计的
+	if run.dryRun {
+		return counts, dropped, nil
 	}
 
-	return tx.Commit(ctx)
+	// pickup 没有独立 id：本次出现的卡池整组替换
+	pickupColumns := []string{"region", "gacha_id", "card_id", "character_id"}
+	if _, err := copyToStage(ctx, tx, "pjsk_gacha_pickups", pickupColumns, pgx.CopyFromRows(pickups)); err != nil {
+		return counts, nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM pjsk_gacha_pickups WHERE region=$1 AND gacha_id = ANY($2)`, region, gachaIDs); err != nil {
+		return counts, nil, err
+	}
+	if _, err := tx.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO pjsk_gacha_pickups (region, gacha_id, card_id, character_id)
+		SELECT region, gacha_id, card_id, character_id FROM %s
+	`, stageTable("pjsk_gacha_pickups"))); err != nil {
+		return counts, nil, err
+	}
+	return counts, dropped, nil
 }
 
-func upsertEvents(ctx context.Context, pool *pgxpool.Pool, events []sekai.Event) error {
-	batch := &pgx.Batch{}
-	for _, e := range events {
-		batch.Queue(`
-			INSERT INTO pjsk_events
-			  (id, event_type, name, assetbundle_name, bgm_assetbundle_name,
-			   event_only_component_display_start_at, start_at, aggregate_at, ranking_announce_at,
-			   distribution_start_at, event_only_component_display_end_at, closed_at, updated_at)
-			VALUES
-			  ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12, now())
-			ON CONFLICT (id) DO UPDATE SET
-			  event_type=EXCLUDED.event_type,
-			  name=EXCLUDED.name,
-			  assetbundle_name=EXCLUDED.assetbundle_name,
-			  bgm_assetbundle_name=EXCLUDED.bgm_assetbundle_name,
-			  event_only_component_display_start_at=EXCLUDED.event_only_component_display_start_at,
-			  start_at=EXCLUDED.start_at,
-			  aggregate_at=EXCLUDED.aggregate_at,
-			  ranking_announce_at=EXCLUDED.ranking_announce_at,
-			  distribution_start_at=EXCLUDED.distribution_start_at,
-			  event_only_component_display_end_at=EXCLUDED.event_only_component_display_end_at,
-			  closed_at=EXCLUDED.closed_at,
-			  updated_at=now()
-		`,
-			e.ID, e.EventType, e.Name, e.AssetbundleName, e.BgmAssetbundleName,
+// storedIDs 返回 table 里该服务器已有的 id（含软删除的，外键不区分）
+func storedIDs(ctx context.Context, tx pgx.Tx, table, region string) (map[int]bool, error) {
+	rows, err := tx.Query(ctx, fmt.Sprintf(`SELECT id FROM %s WHERE region=$1`, table), region)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		known[id] = true
+	}
+	return known, nil
+}
+
+func upsertEvents(ctx context.Context, tx pgx.Tx, run syncRun, region string, events iter.Seq2[sekai.Event, error]) (upsertCounts, error) {
+	rows := stageStream(events, func(e sekai.Event) []any {
+		return []any{
+			region, e.ID, e.EventType, e.Name, e.AssetbundleName, e.BgmAssetbundleName,
 			msToSec(e.EventOnlyComponentDisplayStart),
 			msToSec(e.StartAt),
 			msToSec(e.AggregateAt),
@@ -213,21 +693,128 @@ func upsertEvents(ctx context.Context, pool *pgxpool.Pool, events []sekai.Event)
 			msToSec(e.DistributionStartAt),
 			msToSec(e.EventOnlyComponentDisplayEnd),
 			msToSec(e.ClosedAt),
-		)
+			e.Raw,
+		}
+	})
+	return mergeStaged(ctx, tx, run, stagedEntity{
+		table: "pjsk_events",
+		key:   "id",
+		columns: []string{
+			"region", "id", "event_type", "name", "assetbundle_name", "bgm_assetbundle_name",
+			"event_only_component_display_start_at", "start_at", "aggregate_at", "ranking_announce_at",
+			"distribution_start_at", "event_only_component_display_end_at", "closed_at", "raw",
+		},
+		rows:      rows,
+		tombstone: true,
+	})
+}
+
+func upsertEventCards(ctx context.Context, tx pgx.Tx, run syncRun, region string, eventCards []sekai.EventCard, events []sekai.Event, knownCards map[int]bool) (upsertCounts, error) {
+	// 外键指向 pjsk_events / pjsk_cards：跳过活动或卡面尚未收录的记录
+	knownEvents := make(map[int]bool, len(events))
+	for _, e := range events {
+		knownEvents[e.ID] = true
 	}
-	br := pool.SendBatch(ctx, batch)
-	defer br.Close()
-	for range events {
-		if _, err := br.Exec(); err != nil {
-			return err
+
+	eventCards = dedupeLast(eventCards, func(ec sekai.EventCard) int { return ec.ID })
+	rows := stageRows(slices.Values(eventCards), func(ec sekai.EventCard) []any {
+		if !knownEvents[ec.EventID] {
+			return nil
 		}
+		if !knownCards[ec.CardID] {
+			return nil
+		}
+		return []any{region, ec.ID, ec.EventID, ec.CardID, ec.BonusRate, ec.Raw}
+	})
+	return mergeStaged(ctx, tx, run, stagedEntity{
+		table:   "pjsk_event_cards",
+		key:     "id",
+		columns: []string{"region", "id", "event_id", "card_id", "bonus_rate", "raw"},
+		rows:    rows,
+	})
+}
+
+func upsertEventDeckBonuses(ctx context.Context, tx pgx.Tx, run syncRun, region string, bonuses []sekai.EventDeckBonus, events []sekai.Event) (upsertCounts, error) {
+	knownEvents := make(map[int]bool, len(events))
+	for _, e := range events {
+		knownEvents[e.ID] = true
 	}
-	return nil
+
+	bonuses = dedupeLast(bonuses, func(b sekai.EventDeckBonus) int { return b.ID })
+	rows := stageRows(slices.Values(bonuses), func(b sekai.EventDeckBonus) []any {
+		if !knownEvents[b.EventID] {
+			return nil
+		}
+		// 0 / 空串在 master 里表示“不限”，落库为 NULL
+		var unitID, attr any
+		if b.GameCharacterUnitID != 0 {
+			unitID = b.GameCharacterUnitID
+		}
+		if b.CardAttr != "" {
+			attr = b.CardAttr
+		}
+		return []any{region, b.ID, b.EventID, unitID, attr, b.BonusRate, b.Raw}
+	})
+	return mergeStaged(ctx, tx, run, stagedEntity{
+		table:   "pjsk_event_deck_bonuses",
+		key:     "id",
+		columns: []string{"region", "id", "event_id", "game_character_unit_id", "card_attr", "bonus_rate", "raw"},
+		rows:    rows,
+	})
+}
+
+func upsertMusics(ctx context.Context, tx pgx.Tx, run syncRun, region string, musics []sekai.Music) (upsertCounts, error) {
+	musics = dedupeLast(musics, func(m sekai.Music) int { return m.ID })
+	rows := stageRows(slices.Values(musics), func(m sekai.Music) []any {
+		categories := m.Categories
+		if categories == nil {
+			categories = []string{}
+		}
+		return []any{
+			region, m.ID, m.Seq, m.Title, m.Pronunciation, categories, m.Lyricist, m.Composer, m.Arranger,
+			m.AssetbundleName, m.FillerSec, msToSec(m.PublishedAt), msToSec(m.ReleasedAt), m.Raw,
+		}
+	})
+	return mergeStaged(ctx, tx, run, stagedEntity{
+		table: "pjsk_musics",
+		key:   "id",
+		columns: []string{
+			"region", "id", "seq", "title", "pronunciation", "categories", "lyricist", "composer", "arranger",
+			"assetbundle_name", "filler_sec", "published_at", "released_at", "raw",
+		},
+		rows:      rows,
+		tombstone: true,
+	})
+}
+
+func upsertMusicDifficulties(ctx context.Context, tx pgx.Tx, run syncRun, region string, difficulties []sekai.MusicDifficulty, musics []sekai.Music) (upsertCounts, error) {
+	// 难度表外键指向 pjsk_musics：跳过歌曲尚未收录的孤儿记录
+	known := make(map[int]bool, len(musics))
+	for _, m := range musics {
+		known[m.ID] = true
+	}
+
+	difficulties = dedupeLast(difficulties, func(d sekai.MusicDifficulty) int { return d.ID })
+	rows := stageRows(slices.Values(difficulties), func(d sekai.MusicDifficulty) []any {
+		if !known[d.MusicID] {
+			return nil
+		}
+		return []any{region, d.ID, d.MusicID, d.MusicDifficulty, d.PlayLevel, d.TotalNoteCount, d.Raw}
+	})
+	return mergeStaged(ctx, tx, run, stagedEntity{
+		table:   "pjsk_music_difficulties",
+		key:     "id",
+		columns: []string{"region", "id", "music_id", "music_difficulty", "play_level", "total_note_count", "raw"},
+		rows:    rows,
+	})
 }
 
 type assetJob struct {
+	kind    string   // card_normal / card_after_training / event_logo / event_bg / gacha_banner
 	destRel string   // 相对 IMAGE_REPO_DIR 的路径
 	urls    []string // fallback
+
+	releaseAt int64 // 仅 card_normal：卡面发布时间（ms），新卡缩略图策略用
 }
 
 func fileExists(path string) bool {
@@ -246,71 +833,97 @@ func writeFileAtomic(path string, data []byte) error {
 	return os.Rename(tmp, path)
 }
 
-func syncAssetsToDir(ctx context.Context, cfg config.Config, cards []sekai.Card, events []sekai.Event, gachas []sekai.Gacha) error {
-	root := cfg.ImageRepoDir
-	if root == "" {
-		return fmt.Errorf("IMAGE_REPO_DIR is empty")
+// assetJobs 列出该服务器应有的全部素材（相对 IMAGE_REPO_DIR 的落盘路径 + 候选 URL）
+func assetJobs(cfg config.Config, src config.RegionSource, cards []sekai.Card, events []sekai.Event, gachas []sekai.Gacha) []assetJob {
+	// 本服优先，其余服务器按 ASSET_FALLBACK_REGIONS 顺序兜底；落盘路径始终归属本服
+	primary := assets.NewSource(src.Assets)
+	chain := []assets.Source{primary}
+	for _, fb := range cfg.AssetFallbacks {
+		if fb.Region != src.Region {
+			chain = append(chain, assets.NewSource(fb))
+		}
+	}
+	urlsOf := func(build func(assets.Source) string) []string {
+		urls := make([]string, 0, len(chain))
+		for _, s := range chain {
+			urls = append(urls, build(s))
+		}
+		return urls
 	}
-
-	dl := assets.NewDownloader()
 
 	var jobs []assetJob
 
 	// cards
 	for _, c := range cards {
+		ab := c.AssetbundleName
 		jobs = append(jobs, assetJob{
-			destRel: fmt.Sprintf("card_thumbnails/%d_normal.webp", c.ID),
-			urls:    []string{assets.CardNormalURLJP(c.AssetbundleName)},
+			kind:      "card_normal",
+			destRel:   primary.DestPath(fmt.Sprintf("card_thumbnails/%d_normal.webp", c.ID)),
+			urls:      urlsOf(func(s assets.Source) string { return s.CardNormalURL(ab) }),
+			releaseAt: c.ReleaseAt,
 		})
 		if c.CardRarityType == "rarity_3" || c.CardRarityType == "rarity_4" {
 			jobs = append(jobs, assetJob{
-				destRel: fmt.Sprintf("card_thumbnails/%d_after_training.webp", c.ID),
-				urls:    []string{assets.CardAfterTrainingURLJP(c.AssetbundleName)},
+				kind:    "card_after_training",
+				destRel: primary.DestPath(fmt.Sprintf("card_thumbnails/%d_after_training.webp", c.ID)),
+				urls:    urlsOf(func(s assets.Source) string { return s.CardAfterTrainingURL(ab) }),
 			})
 		}
 	}
 
 	// events
 	for _, e := range events {
+		ab := e.AssetbundleName
 		jobs = append(jobs, assetJob{
-			destRel: fmt.Sprintf("sekai-events/event_%d/logo.webp", e.ID),
-			urls:    []string{assets.EventLogoURLCN(e.AssetbundleName), assets.EventLogoURLJP(e.AssetbundleName)},
+			kind:    "event_logo",
+			destRel: primary.DestPath(fmt.Sprintf("sekai-events/event_%d/logo.webp", e.ID)),
+			urls:    urlsOf(func(s assets.Source) string { return s.EventLogoURL(ab) }),
 		})
 		jobs = append(jobs, assetJob{
-			destRel: fmt.Sprintf("sekai-events/event_%d/bg.webp", e.ID),
-			urls:    []string{assets.EventBgURLCN(e.AssetbundleName), assets.EventBgURLJP(e.AssetbundleName)},
+			kind:    "event_bg",
+			destRel: primary.DestPath(fmt.Sprintf("sekai-events/event_%d/bg.webp", e.ID)),
+			urls:    urlsOf(func(s assets.Source) string { return s.EventBgURL(ab) }),
 		})
 	}
 
-	// gachas
-	// gachas - banner first, fallback to logo if banner returns 404
+	// gachas - banner first (all regions), fallback to logo if every banner returns 404
 	for _, g := range gachas {
+		id := g.ID
+		urls := urlsOf(func(s assets.Source) string { return s.GachaBannerURL(id) })
+		urls = append(urls, urlsOf(func(s assets.Source) string { return s.GachaLogoURL(id) })...)
 		jobs = append(jobs, assetJob{
-			destRel: fmt.Sprintf("sekai-gachas/gacha_%d/banner.webp", g.ID),
-			urls: []string{
-				assets.GachaBannerURLCN(g.ID),
-				assets.GachaBannerURLJP(g.ID),
-				assets.GachaLogoURLCN(g.ID), // fallback: logo CN
-				assets.GachaLogoURLJP(g.ID), // fallback: logo JP
-			},
+			kind:    "gacha_banner",
+			destRel: primary.DestPath(fmt.Sprintf("sekai-gachas/gacha_%d/banner.webp", g.ID)),
+			urls:    urls,
 		})
 	}
 
+	return jobs
+}
+
+func syncAssetsToDir(ctx context.Context, hc *httpx.Client, cfg config.Config, src config.RegionSource, cards []sekai.Card, events []sekai.Event, gachas []sekai.Gacha, rr *RegionReport) error {
+	root := cfg.ImageRepoDir
+	if root == "" {
+		return fmt.Errorf("IMAGE_REPO_DIR is empty")
+	}
+
+	jobs := assetJobs(cfg, src, cards, events, gachas)
+	if cfg.DryRun {
+		logPlannedAssets(src.Region, root, jobs, rr)
+		return nil
+	}
+	dl := assets.NewDownloader(hc)
+
 	sem := make(chan struct{}, cfg.MaxConcurrency)
 	var wg sync.WaitGroup
 
-	var mu sync.Mutex
-	var downloaded, skipped int
-
 	for _, j := range jobs {
 		j := j
 		destAbs := filepath.Join(root, j.destRel)
 
 		// 1. Check if WebP already exists
 		if fileExists(destAbs) {
-			mu.Lock()
-			skipped++
-			mu.Unlock()
+			rr.asset(j.kind, assetExisting)
 			continue
 		}
 
@@ -328,9 +941,7 @@ func syncAssetsToDir(ctx context.Context, cfg config.Config, cards []sekai.Card,
 					if err := writeFileAtomic(destAbs, webpData); err == nil {
 						// Remove legacy file upon success
 						_ = os.Remove(legacyPngPath)
-						mu.Lock()
-						downloaded++ // counted as processed/saved
-						mu.Unlock()
+						rr.asset(j.kind, assetMigrated)
 						log.Printf("asset migrated to webp: %s", j.destRel)
 						continue
 					}
@@ -349,18 +960,25 @@ func syncAssetsToDir(ctx context.Context, cfg config.Config, cards []sekai.Card,
 
 			// 逐 URL 尝试
 			var content []byte
-			var lastStatus int
+			var attempts []AssetAttempt
 			for _, u := range j.urls {
 				b, status, err := dl.Get(ctx, u)
-				lastStatus = status
 				if err == nil && status >= 200 && status < 300 && len(b) > 0 {
 					content = b
 					break
 				}
+				a := AssetAttempt{URL: u, Status: status}
+				if err != nil {
+					a.Error = err.Error()
+				}
+				attempts = append(attempts, a)
 			}
 
 			if content == nil {
+				lastStatus := attempts[len(attempts)-1].Status
 				log.Printf("asset miss (download failed): %s last_status=%d", j.destRel, lastStatus)
+				rr.asset(j.kind, assetMissed)
+				rr.miss(AssetMiss{Path: j.destRel, Kind: j.kind, Outcome: assetMissed, Attempts: attempts})
 				return
 			}
 
@@ -369,22 +987,26 @@ func syncAssetsToDir(ctx context.Context, cfg config.Config, cards []sekai.Card,
 				content = converted
 			} else {
 				log.Printf("webp convert failed: %s err=%v", j.destRel, err)
+				rr.asset(j.kind, assetInvalid)
+				rr.miss(AssetMiss{Path: j.destRel, Kind: j.kind, Outcome: assetInvalid, Error: "webp convert: " + err.Error()})
 				return // Fail if conversion fails, as user requested WebP compatibility
 			}
 
 			if err := writeFileAtomic(destAbs, content); err != nil {
 				log.Printf("asset write failed: %s err=%v", j.destRel, err)
+				rr.asset(j.kind, assetFailed)
+				rr.miss(AssetMiss{Path: j.destRel, Kind: j.kind, Outcome: assetFailed, Error: "write: " + err.Error()})
 				return
 			}
 
-			mu.Lock()
-			downloaded++
-			mu.Unlock()
+			rr.asset(j.kind, assetSaved)
 			log.Printf("asset saved: %s", j.destRel)
 		}()
 	}
 
 	wg.Wait()
-	log.Printf("assets: saved=%d skipped(existing)=%d total=%d", downloaded, skipped, len(jobs))
-	return nil
+	t := rr.assetTotals()
+	log.Printf("assets [%s]: saved=%d migrated=%d skipped(existing)=%d missed=%d invalid=%d failed=%d total=%d",
+		src.Region, t.Saved, t.Migrated, t.Existing, t.Missed, t.Invalid, t.Failed, len(jobs))
+	return checkAssetPolicies(cfg, src.Region, jobs, rr, time.Now())
 }
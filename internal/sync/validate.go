@@ -0,0 +1,161 @@
+package sync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"pjsk-sync/internal/sekai"
+)
+
+// 每个文件最多逐条打印这么多被隔离的记录，其余只计数（完整列表见 QUARANTINE_REPORT）
+const maxQuarantineLogs = 20
+
+type quarantined struct {
+	Region string `json:"region"`
+	sekai.Invalid
+}
+
+// validateRegion 校验本次拉取到的数据组，剔除不合法的记录；
+// 任一文件的不合法比例超过 maxPct 时报错，防止截断的 upstream 文件经 reconcile 清空数据。
+func validateRegion(m *regionMaster, maxPct float64) error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if m.profilesChanged {
+		check(screen(m, "unitProfiles", &m.units, maxPct))
+		check(screen(m, "gameCharacters", &m.characters, maxPct))
+	}
+	if m.gachaChanged {
+		var err error
+		m.cards, err = screenFile(m, "cards", &m.cardsFile, maxPct, cardSummary)
+		check(err)
+		m.gachas, err = screenFile(m, "gachas", &m.gachasFile, maxPct, gachaSummary)
+		check(err)
+		m.events, err = screenFile(m, "events", &m.eventsFile, maxPct, eventSummary)
+		check(err)
+		check(screen(m, "eventCards", &m.eventCards, maxPct))
+		check(screen(m, "eventDeckBonuses", &m.deckBonuses, maxPct))
+	}
+	if m.musicChanged {
+		check(screen(m, "musics", &m.musics, maxPct))
+		check(screen(m, "musicDifficulties", &m.difficulties, maxPct))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+	return nil
+}
+
+func screen[T sekai.Record](m *regionMaster, entity string, items *[]T, maxPct float64) error {
+	valid, bad := sekai.Validate(entity, *items)
+	*items = valid
+	return quarantine(m, entity, bad, len(valid)+len(bad), maxPct)
+}
+
+// screenFile 流式校验 f，返回通过校验的记录经 summary 精简后的结果（素材任务、reconcile、
+// 外键过滤用）；完整记录落库时再从 f.records 读。
+func screenFile[T sekai.Record](m *regionMaster, entity string, f *spooledFile[T], maxPct float64, summary func(T) T) ([]T, error) {
+	f.screen = sekai.NewScreen[T](entity)
+	type kept struct {
+		index int
+		v     T
+	}
+	var all []kept
+	st, err := sekai.OpenFile[T](f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+	for v, err := range st.All() {
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, kept{f.screen.Add(v), summary(v)})
+	}
+
+	// 被后面的同 key 记录覆盖的条目要读完才知道，最后再过滤
+	out := make([]T, 0, len(all))
+	for _, k := range all {
+		if !f.screen.Skip(k.index) {
+			out = append(out, k.v)
+		}
+	}
+	return out, quarantine(m, entity, f.screen.Invalid(), f.screen.Len(), maxPct)
+}
+
+// quarantine 记下被剔除的记录；比例超过 maxPct 时报错
+func quarantine(m *regionMaster, entity string, bad []sekai.Invalid, total int, maxPct float64) error {
+	if len(bad) == 0 {
+		return nil
+	}
+
+	for i, b := range bad {
+		if i < maxQuarantineLogs {
+			log.Printf("quarantine [%s] %s[%d] key=%q: %s", m.src.Region, entity, b.Index, b.Key, b.Reason)
+		}
+		m.quarantine = append(m.quarantine, quarantined{Region: m.src.Region, Invalid: b})
+	}
+	if len(bad) > maxQuarantineLogs {
+		log.Printf("quarantine [%s] %s: %d more not shown", m.src.Region, entity, len(bad)-maxQuarantineLogs)
+	}
+
+	pct := float64(len(bad)) * 100 / float64(total)
+	if pct > maxPct {
+		return fmt.Errorf("%s: %d/%d records invalid (%.1f%% > %.1f%%)", entity, len(bad), total, pct, maxPct)
+	}
+	return nil
+}
+
+// drop 记下落库时因外键目标不存在而跳过的记录，与校验时隔离的记录一起写进报告（不计入比例）
+func (m *regionMaster) drop(b sekai.Invalid) {
+	log.Printf("quarantine [%s] %s[%d] key=%q: %s", m.src.Region, b.Entity, b.Index, b.Key, b.Reason)
+	m.dropped = append(m.dropped, quarantined{Region: m.src.Region, Invalid: b})
+}
+
+// 被隔离记录对应的表，reconcile 时当作仍然存在
+var quarantineTables = map[string]string{
+	"cards":  "pjsk_cards",
+	"gachas": "pjsk_gachas",
+	"events": "pjsk_events",
+	"musics": "pjsk_musics",
+}
+
+func quarantinedIDs(records []quarantined) map[string][]int {
+	out := map[string][]int{}
+	for _, q := range records {
+		table, ok := quarantineTables[q.Entity]
+		if !ok {
+			continue
+		}
+		if id, err := strconv.Atoi(q.Key); err == nil && id > 0 {
+			out[table] = append(out[table], id)
+		}
+	}
+	return out
+}
+
+// writeQuarantineReport 把所有被隔离的记录写成 JSON；path 为空时不写
+func writeQuarantineReport(path string, records []quarantined) error {
+	if path == "" {
+		return nil
+	}
+	if records == nil {
+		records = []quarantined{}
+	}
+	b, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
@@ -0,0 +1,84 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"pjsk-sync/internal/config"
+)
+
+// 每个服务器最多逐条打印这么多缺失的素材
+const maxMissingLogs = 20
+
+// 数据完整性检查：每条查询返回有问题的行数
+var integrityChecks = []struct {
+	name string
+	sql  string
+}{
+	{"cards without assetbundle_name",
+		`SELECT count(*) FROM pjsk_cards WHERE region=$1 AND deleted_at IS NULL AND assetbundle_name = ''`},
+	{"events without assetbundle_name",
+		`SELECT count(*) FROM pjsk_events WHERE region=$1 AND deleted_at IS NULL AND assetbundle_name = ''`},
+	// character 外键是 NOT VALID，历史数据可能不满足
+	{"cards with unknown character",
+		`SELECT count(*) FROM pjsk_cards c WHERE c.region=$1 AND NOT EXISTS (
+			SELECT 1 FROM pjsk_characters ch WHERE ch.region = c.region AND ch.id = c.character_id)`},
+	{"pickups of removed cards on live gachas",
+		`SELECT count(*) FROM pjsk_gacha_pickups p
+			JOIN pjsk_gachas g ON g.region = p.region AND g.id = p.gacha_id AND g.deleted_at IS NULL
+			JOIN pjsk_cards c ON c.region = p.region AND c.id = p.card_id AND c.deleted_at IS NOT NULL
+			WHERE p.region=$1`},
+}
+
+// VerifyResult 是 Verify 发现的问题数
+type VerifyResult struct {
+	Integrity     int // 完整性检查不通过的行数合计
+	MissingAssets int // 库里有记录、IMAGE_REPO_DIR 下却没有的素材
+}
+
+func (r VerifyResult) OK() bool { return r.Integrity == 0 && r.MissingAssets == 0 }
+
+// Verify 只读地检查库内数据完整性，以及图床目录是否齐全（不访问网络）
+func Verify(ctx context.Context, pool *pgxpool.Pool, cfg config.Config) (VerifyResult, error) {
+	var res VerifyResult
+	for _, src := range cfg.Regions {
+		region := src.Region
+
+		for _, c := range integrityChecks {
+			var n int
+			if err := pool.QueryRow(ctx, c.sql, region).Scan(&n); err != nil {
+				return res, fmt.Errorf("region %s: %s: %w", region, c.name, err)
+			}
+			if n > 0 {
+				log.Printf("verify [%s]: %s: %d", region, c.name, n)
+				res.Integrity += n
+			}
+		}
+
+		if cfg.ImageRepoDir == "" {
+			continue
+		}
+		m := regionMaster{src: src}
+		if err := loadAssetInputs(ctx, pool, &m); err != nil {
+			return res, fmt.Errorf("region %s: load asset inputs: %w", region, err)
+		}
+		jobs := assetJobs(cfg, src, m.cards, m.events, m.gachas)
+		var missing int
+		for _, j := range jobs {
+			if fileExists(filepath.Join(cfg.ImageRepoDir, j.destRel)) {
+				continue
+			}
+			if missing < maxMissingLogs {
+				log.Printf("verify [%s]: missing asset %s", region, j.destRel)
+			}
+			missing++
+		}
+		log.Printf("verify [%s]: assets present=%d missing=%d", region, len(jobs)-missing, missing)
+		res.MissingAssets += missing
+	}
+	return res, nil
+}